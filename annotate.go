@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"strings"
+	"text/template"
+
+	"gocv.io/x/gocv"
+)
+
+// defaultLabelTemplate is the default label drawn above each detection
+const defaultLabelTemplate = `Size: {{.Width}}x{{.Height}}` +
+	`{{if .MaskKnown}}{{if .Masked}} Mask{{else}} No mask{{end}} {{pct .MaskConfidence}}{{end}}` +
+	`{{with .Expression}} {{.}} {{pct $.ExpressionProbability}}{{end}}`
+
+// annotation is the style detections are drawn with. It's only changed at
+// startup.
+var annotation = annotationStyle{
+	thickness: 2,
+	fontScale: 1.0,
+	label:     template.Must(newLabelTemplate(defaultLabelTemplate)),
+}
+
+// annotationConfig holds the settings for how detections are drawn on frames.
+type annotationConfig struct {
+	thickness int
+	fontScale float64
+	label     string
+	colors    string
+}
+
+func (c *annotationConfig) registerFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.thickness, "annotate-thickness", 2, "line thickness of detection rectangles and labels, in pixels")
+	fs.Float64Var(&c.fontScale, "annotate-font-scale", 1.0, "font scale of detection labels")
+	fs.StringVar(&c.label, "annotate-label", defaultLabelTemplate, "Go template for the label drawn above each detection, or empty for no label")
+	fs.StringVar(&c.colors, "annotate-colors", "", "comma-separated classifier colors, overriding the defaults, like haar=#00ff00,eye=#0000ff")
+}
+
+// annotationStyle is the parsed annotation configuration.
+type annotationStyle struct {
+	thickness int
+	fontScale float64
+	// label is nil when labels are disabled
+	label *template.Template
+	// colors override classifiers' colors, by name
+	colors map[string]string
+}
+
+// labelData is available to label templates.
+type labelData struct {
+	Classifier string
+	Width      int
+	Height     int
+	// MaskKnown is whether the face was classified as masked or not, with
+	// Masked
+	MaskKnown bool
+	Masked    bool
+	// MaskConfidence is the probability of the Masked label
+	MaskConfidence float64
+	// Expression is the face's expression, if classified
+	Expression            string
+	ExpressionProbability float64
+}
+
+func newLabelTemplate(text string) (*template.Template, error) {
+	return template.New("label").Funcs(template.FuncMap{
+		"pct": func(p float64) string { return fmt.Sprintf("%.0f%%", p*100) },
+	}).Parse(text)
+}
+
+// style validates and parses the configuration.
+func (c annotationConfig) style() (annotationStyle, error) {
+	s := annotationStyle{thickness: c.thickness, fontScale: c.fontScale, colors: map[string]string{}}
+
+	if c.thickness <= 0 {
+		return s, fmt.Errorf("invalid -annotate-thickness %d: must be positive", c.thickness)
+	}
+
+	if c.fontScale <= 0 {
+		return s, fmt.Errorf("invalid -annotate-font-scale %v: must be positive", c.fontScale)
+	}
+
+	if c.label != "" {
+		t, err := newLabelTemplate(c.label)
+		if err != nil {
+			return s, fmt.Errorf("invalid -annotate-label: %w", err)
+		}
+		s.label = t
+	}
+
+	for _, kv := range strings.Split(c.colors, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+
+		name, col, ok := strings.Cut(kv, "=")
+		if !ok {
+			return s, fmt.Errorf("invalid -annotate-colors %q: must be like haar=#00ff00", kv)
+		}
+
+		if _, err := parseColor(col); err != nil {
+			return s, fmt.Errorf("invalid -annotate-colors: %w", err)
+		}
+		s.colors[name] = col
+	}
+
+	return s, nil
+}
+
+// draw outlines a detection, and labels it.
+func (s annotationStyle) draw(img *gocv.Mat, r image.Rectangle, c color.RGBA, data labelData) {
+	gocv.Rectangle(img, r, c, s.thickness)
+
+	if s.label == nil {
+		return
+	}
+
+	b := &strings.Builder{}
+	if err := s.label.Execute(b, data); err != nil {
+		slog.Warn("rendering detection label", "err", err)
+		return
+	}
+
+	gocv.PutText(img, b.String(), image.Pt(r.Min.X, r.Min.Y-10), font, s.fontScale, c, s.thickness)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+const (
+	audioSampleRate = 16000
+	// audioMinLevel is the level (in dBFS) below which sound is always
+	// considered silence, no matter how quiet the room is
+	audioMinLevel = -55.0
+	// audioActiveRatio is the fraction of a second's worth of audio chunks
+	// that must be louder than the noise floor for it to count as activity
+	audioActiveRatio = 0.3
+)
+
+// audioConfig holds the settings for microphone activity detection.
+type audioConfig struct {
+	enable  bool
+	margin  float64
+	timeout time.Duration
+	weight  float64
+}
+
+func (c *audioConfig) registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.enable, "audio", false, "use microphone activity (voices, typing) as a presence signal")
+	fs.Float64Var(&c.margin, "audio-margin", 10, "how far (in dB) above the background noise floor sound must be to count as activity")
+	fs.DurationVar(&c.timeout, "audio-timeout", time.Minute, "freshness window: how long microphone activity counts as presence")
+	fs.Float64Var(&c.weight, "audio-weight", 1, "weight of microphone activity in the fusion policy")
+}
+
+func (c audioConfig) enabled() bool {
+	return c.enable
+}
+
+// audioDetector is a simple energy-based voice activity detector. It tracks
+// the background noise floor, and reports activity when enough of the audio
+// over a second is louder than that by the configured margin. Audio is never
+// stored.
+type audioDetector struct {
+	cfg audioConfig
+
+	mu           sync.Mutex
+	noiseFloor   float64
+	chunks       int
+	active       int
+	windowEnd    time.Time
+	lastActivity time.Time
+}
+
+func newAudioDetector(cfg audioConfig) *audioDetector {
+	return &audioDetector{cfg: cfg, noiseFloor: audioMinLevel}
+}
+
+// listen starts capturing from the default microphone. The returned function
+// stops capture and releases the device.
+func (d *audioDetector) listen() (func(), error) {
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("initializing audio: %w", err)
+	}
+
+	cfg := malgo.DefaultDeviceConfig(malgo.Capture)
+	cfg.Capture.Format = malgo.FormatS16
+	cfg.Capture.Channels = 1
+	cfg.SampleRate = audioSampleRate
+
+	device, err := malgo.InitDevice(ctx.Context, cfg, malgo.DeviceCallbacks{
+		Data: func(_, in []byte, _ uint32) {
+			d.process(time.Now(), in)
+		},
+	})
+	if err != nil {
+		_ = ctx.Uninit()
+		ctx.Free()
+
+		return nil, fmt.Errorf("opening microphone: %w", err)
+	}
+
+	if err := device.Start(); err != nil {
+		device.Uninit()
+		_ = ctx.Uninit()
+		ctx.Free()
+
+		return nil, fmt.Errorf("starting microphone capture: %w", err)
+	}
+
+	slog.Info("Listening for microphone activity")
+
+	return func() {
+		device.Uninit()
+		_ = ctx.Uninit()
+		ctx.Free()
+	}, nil
+}
+
+// process handles a chunk of signed 16-bit mono samples.
+func (d *audioDetector) process(now time.Time, samples []byte) {
+	level := audioLevel(samples)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the noise floor drops quickly but rises slowly, so that sustained
+	// speech doesn't get mistaken for background noise
+	if level < d.noiseFloor {
+		d.noiseFloor = math.Max(level, audioMinLevel)
+	} else {
+		d.noiseFloor += (level - d.noiseFloor) * 0.001
+	}
+
+	d.chunks++
+	if level > audioMinLevel && level > d.noiseFloor+d.cfg.margin {
+		d.active++
+	}
+
+	if now.Before(d.windowEnd) {
+		return
+	}
+
+	if d.chunks > 0 && float64(d.active)/float64(d.chunks) >= audioActiveRatio {
+		d.lastActivity = now
+	}
+
+	d.chunks, d.active = 0, 0
+	d.windowEnd = now.Add(time.Second)
+}
+
+// audioLevel returns the RMS level of the samples in dBFS.
+func audioLevel(samples []byte) float64 {
+	n := len(samples) / 2
+	if n == 0 {
+		return math.Inf(-1)
+	}
+
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		s := float64(int16(binary.LittleEndian.Uint16(samples[i*2:]))) / math.MaxInt16
+		sum += s * s
+	}
+
+	return 20 * math.Log10(math.Sqrt(sum/float64(n)))
+}
+
+func (d *audioDetector) lastActive() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.lastActivity
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// audit records API access and configuration changes, when -audit-log is
+// set. It's nil otherwise.
+var audit *auditLog
+
+// minAuditKeyLen is the shortest audit key accepted, so the chain can't be
+// forged by guessing it
+const minAuditKeyLen = 16
+
+// auditConfig holds the audit log settings.
+type auditConfig struct {
+	file    string
+	keyFile string
+}
+
+func (c *auditConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "audit-log", "", "append-only file to record snapshot access and configuration changes to, hash-chained so changes can be detected (check with presence audit-verify)")
+	fs.StringVar(&c.keyFile, "audit-key", "", "file containing a secret key to chain -audit-log with HMAC-SHA256, so that changes can't be covered up without it - keep it away from the log (default plain SHA-256, which only detects accidental corruption)")
+}
+
+func (c auditConfig) enabled() bool {
+	return c.file != ""
+}
+
+// auditEntry is a line of the audit log. Each entry's hash covers the
+// previous entry's hash, so removing or changing an entry breaks the chain.
+// The hash is an HMAC when there's an audit key, so the chain can't be
+// recomputed without it.
+type auditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor identifies who made the request - a fingerprint of their bearer
+	// token, if any, or otherwise their address
+	Actor  string `json:"actor"`
+	Remote string `json:"remote"`
+	// Action is "access" for image access, or "config" for changes
+	Action string `json:"action"`
+	// Method is the HTTP method, or "gRPC" with the gRPC method as the path
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status,omitempty"`
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+// digest returns the hash of the entry, excluding its own hash - an
+// HMAC-SHA256 with the key, or a SHA-256 when key is nil.
+func (e auditEntry) digest(key []byte) string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+
+	if key == nil {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readAuditKey reads the audit key from a file, ignoring surrounding
+// whitespace.
+func readAuditKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading audit key: %w", err)
+	}
+
+	key := bytes.TrimSpace(b)
+	if len(key) < minAuditKeyLen {
+		return nil, fmt.Errorf("audit key in %s is too short: must be at least %d bytes", path, minAuditKeyLen)
+	}
+
+	return key, nil
+}
+
+// auditLog appends hash-chained entries to a file.
+type auditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	key  []byte
+	last string
+}
+
+// openAuditLog opens the audit log for appending, continuing the chain from
+// its last entry. Entries are chained with an HMAC when key isn't nil.
+func openAuditLog(path string, key []byte) (*auditLog, error) {
+	last, err := lastAuditHash(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+
+	return &auditLog{f: f, key: key, last: last}, nil
+}
+
+// lastAuditHash returns the hash of the last entry in the log, or "" when
+// it's empty or doesn't exist yet.
+func lastAuditHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading audit log: %w", err)
+	}
+	defer f.Close()
+
+	last := ""
+	err = scanAuditLog(f, func(_ int, e auditEntry) error {
+		last = e.Hash
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading audit log %s: %w", path, err)
+	}
+
+	return last, nil
+}
+
+// scanAuditLog calls fn with each entry in the log, and its line number.
+func scanAuditLog(r io.Reader, fn func(line int, e auditEntry) error) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		e := auditEntry{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if err := fn(n, e); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+func (l *auditLog) record(e auditEntry) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Prev = l.last
+	e.Hash = e.digest(l.key)
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("encoding audit entry", "err", err)
+		return
+	}
+
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		slog.Error("writing audit log", "err", err)
+		return
+	}
+
+	l.last = e.Hash
+}
+
+func (l *auditLog) close() error {
+	return l.f.Close()
+}
+
+// auditActor identifies who made the request, by the name of their API key,
+// or a fingerprint of the bearer token they sent (as forwarded by an
+// authenticating proxy), or by their address.
+func auditActor(r *http.Request) string {
+	token := requestToken(r)
+	if k := apiKeys.lookup(token); k != nil {
+		return "key:" + k.Name
+	}
+
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+
+	return "addr:" + clientAddr(r)
+}
+
+// audited wraps a handler so its requests are recorded in the audit log, as
+// the given action.
+func audited(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if audit == nil {
+			h(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, r)
+
+		audit.record(auditEntry{
+			Time:   time.Now(),
+			Actor:  auditActor(r),
+			Remote: r.RemoteAddr,
+			Action: action,
+			Method: r.Method,
+			Path:   redactedURI(r),
+			Status: sw.status,
+		})
+	}
+}
+
+// statusWriter records the response status.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// flushing streams.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// runAuditVerify implements the audit-verify subcommand, which checks that
+// an audit log's hash chain is intact, like:
+//
+//	presence audit-verify -key /etc/presence/audit.key /var/log/presence-audit.jsonl
+func runAuditVerify(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	keyFile := fs.String("key", "", "file containing the -audit-key the log was written with, if any")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s file\n", os.Args[0], cmd)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("no audit log given")
+	}
+
+	var key []byte
+	if *keyFile != "" {
+		var err error
+		if key, err = readAuditKey(*keyFile); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	count, err := verifyAuditLog(f, key)
+	if err != nil {
+		return fmt.Errorf("audit log %s is not intact: %w", fs.Arg(0), err)
+	}
+
+	fmt.Printf("%s: %d entries, chain intact\n", fs.Arg(0), count)
+
+	return nil
+}
+
+// verifyAuditLog checks the log's hash chain with the key (nil for plain
+// SHA-256), and returns how many entries it has.
+func verifyAuditLog(r io.Reader, key []byte) (int, error) {
+	prev, count := "", 0
+	err := scanAuditLog(r, func(line int, e auditEntry) error {
+		if e.Prev != prev {
+			return fmt.Errorf("line %d: chain broken - an entry before it was removed or changed", line)
+		}
+
+		if !hmac.Equal([]byte(e.digest(key)), []byte(e.Hash)) {
+			return fmt.Errorf("line %d: hash mismatch - the entry was changed, or the key is wrong", line)
+		}
+
+		prev = e.Hash
+		count++
+
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAuditLog records n entries in a new audit log, returning its lines.
+func writeAuditLog(t *testing.T, key []byte, n int) [][]byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := openAuditLog(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range n {
+		l.record(auditEntry{
+			Time:   time.Date(2024, 5, 3, 9, i, 0, 0, time.UTC),
+			Actor:  "key:test",
+			Remote: "10.0.0.7:51544",
+			Action: "access",
+			Method: "GET",
+			Path:   "/snapshot",
+			Status: 200,
+		})
+	}
+
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.SplitAfter(b, []byte("\n"))
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name string
+		// key is the key the log is written with
+		key []byte
+		// verifyKey is the key it's checked with
+		verifyKey []byte
+		change    func(lines [][]byte) [][]byte
+		want      string
+	}{
+		{name: "intact"},
+		{name: "intact, keyed", key: key, verifyKey: key},
+		{
+			name: "truncated end", key: key, verifyKey: key,
+			change: func(lines [][]byte) [][]byte { return lines[:3] },
+		},
+		{
+			name: "changed entry",
+			change: func(lines [][]byte) [][]byte {
+				lines[2] = bytes.Replace(lines[2], []byte("key:test"), []byte("key:else"), 1)
+				return lines
+			},
+			want: "line 3: hash mismatch",
+		},
+		{
+			name:   "removed entry",
+			change: func(lines [][]byte) [][]byte { return append(lines[:1], lines[2:]...) },
+			want:   "line 2: chain broken",
+		},
+		{
+			name: "swapped entries",
+			change: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			want: "line 2: chain broken",
+		},
+		{name: "wrong key", key: key, verifyKey: []byte("fedcba9876543210"), want: "line 1: hash mismatch"},
+		{name: "keyed, verified without key", key: key, want: "line 1: hash mismatch"},
+		{name: "unkeyed, verified with key", verifyKey: key, want: "line 1: hash mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := writeAuditLog(t, tt.key, 5)
+			if tt.change != nil {
+				lines = tt.change(lines)
+			}
+
+			log := bytes.Join(lines, nil)
+			n, err := verifyAuditLog(bytes.NewReader(log), tt.verifyKey)
+
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want == "" && n != bytes.Count(log, []byte("\n")):
+				t.Errorf("verified %d entries, want %d", n, bytes.Count(log, []byte("\n")))
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditLogContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	for range 2 {
+		l, err := openAuditLog(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.record(auditEntry{Time: time.Now(), Actor: "addr:127.0.0.1", Action: "config", Method: "POST", Path: "/pause"})
+		if err := l.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if n, err := verifyAuditLog(f, nil); err != nil || n != 2 {
+		t.Errorf("verified %d entries with error %v, want 2 and no error", n, err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hairyhenderson/presence/presencepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The scopes API keys can be granted. Each API operation requires one of
+// them, as declared in the OpenAPI spec's security requirements.
+const (
+	// scopeStatus allows reading presence state, signals, and events
+	scopeStatus = "status"
+	// scopeImages allows snapshots and streams from the camera
+	scopeImages = "images"
+	// scopeAdmin allows pausing and configuring, and implies all scopes
+	scopeAdmin = "admin"
+)
+
+var apiScopes = []string{scopeStatus, scopeImages, scopeAdmin}
+
+// minAPIKeyLen is the shortest API key accepted, so keys can't be guessed
+const minAPIKeyLen = 16
+
+// apiKeys are the keys requests must present, when -api-keys is set. It's nil
+// otherwise, and the API is open.
+var apiKeys *apiKeyring
+
+// authConfig holds the API key settings.
+type authConfig struct {
+	file string
+}
+
+func (c *authConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "api-keys", "", "JSON file of API keys, each scoped to status, images, or admin - when set, requests without a key are refused")
+}
+
+func (c authConfig) enabled() bool {
+	return c.file != ""
+}
+
+// apiKey is a named key, granted some scopes.
+type apiKey struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+
+	sum [sha256.Size]byte
+}
+
+// allows reports whether the key is granted the scope.
+func (k *apiKey) allows(scope string) bool {
+	return slices.Contains(k.Scopes, scopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+// apiKeyring holds the accepted API keys.
+type apiKeyring struct {
+	keys []*apiKey
+}
+
+// loadAPIKeys reads a JSON array of API keys, like:
+//
+//	[
+//	  {"name": "home-assistant", "key": "...", "scopes": ["status"]},
+//	  {"name": "dashboard", "key": "...", "scopes": ["status", "images"]}
+//	]
+func loadAPIKeys(path string) (*apiKeyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*apiKey{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no API keys", path)
+	}
+
+	names, sums := map[string]bool{}, map[[sha256.Size]byte]bool{}
+	for i, k := range keys {
+		switch {
+		case k.Name == "":
+			return nil, fmt.Errorf("API key %d has no name", i)
+		case names[k.Name]:
+			return nil, fmt.Errorf("API key %s is defined more than once", k.Name)
+		case len(k.Key) < minAPIKeyLen:
+			return nil, fmt.Errorf("API key %s is too short: must be at least %d characters", k.Name, minAPIKeyLen)
+		case len(k.Scopes) == 0:
+			return nil, fmt.Errorf("API key %s has no scopes", k.Name)
+		}
+
+		for _, s := range k.Scopes {
+			if !slices.Contains(apiScopes, s) {
+				return nil, fmt.Errorf("API key %s has invalid scope %q: must be one of %s", k.Name, s, strings.Join(apiScopes, ", "))
+			}
+		}
+
+		k.sum = sha256.Sum256([]byte(k.Key))
+		if sums[k.sum] {
+			return nil, fmt.Errorf("API key %s is the same as another key", k.Name)
+		}
+
+		names[k.Name], sums[k.sum] = true, true
+	}
+
+	return &apiKeyring{keys: keys}, nil
+}
+
+// lookup returns the key matching token, or nil. Keys are compared by hash,
+// in constant time, so the comparison doesn't leak them.
+func (r *apiKeyring) lookup(token string) *apiKey {
+	if r == nil || token == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(token))
+
+	var found *apiKey
+	for _, k := range r.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.sum[:]) == 1 {
+			found = k
+		}
+	}
+
+	return found
+}
+
+// requestToken returns the API key the request presents, as a bearer token,
+// or as the key query parameter - for clients like <img> tags and the WebRTC
+// viewer, which can't set headers.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+
+	return r.URL.Query().Get("key")
+}
+
+// redactedURI returns the request's URI with any key query parameter
+// redacted, so it's safe to record.
+func redactedURI(r *http.Request) string {
+	q := r.URL.Query()
+	if !q.Has("key") {
+		return r.URL.RequestURI()
+	}
+
+	q.Set("key", "REDACTED")
+	u := url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: q.Encode()}
+
+	return u.RequestURI()
+}
+
+// authorized wraps a handler so that, when API keys are required, requests
+// must present a key granted one of the scopes.
+func authorized(scopes []string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiKeys == nil {
+			h(w, r)
+			return
+		}
+
+		k := apiKeys.lookup(requestToken(r))
+		if k == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="presence"`)
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		if !slices.ContainsFunc(scopes, k.allows) {
+			http.Error(w, fmt.Sprintf("API key %s needs the %s scope", k.Name, strings.Join(scopes, " or ")), http.StatusForbidden)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// grpcScopes are the scopes required by each gRPC method
+var grpcScopes = map[string]string{
+	presencepb.Presence_GetStatus_FullMethodName:    scopeStatus,
+	presencepb.Presence_StreamEvents_FullMethodName: scopeStatus,
+	presencepb.Presence_GetSnapshot_FullMethodName:  scopeImages,
+}
+
+// grpcToken returns the API key sent in the call's authorization metadata.
+func grpcToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// authorizeGRPC checks that, when API keys are required, the call presents a
+// key granted the scope.
+func authorizeGRPC(ctx context.Context, scope string) error {
+	if apiKeys == nil {
+		return nil
+	}
+
+	k := apiKeys.lookup(grpcToken(ctx))
+	if k == nil {
+		return status.Error(codes.Unauthenticated, "missing or invalid API key")
+	}
+
+	if !k.allows(scope) {
+		return status.Errorf(codes.PermissionDenied, "API key %s needs the %s scope", k.Name, scope)
+	}
+
+	return nil
+}
+
+// grpcAuthUnary and grpcAuthStream authorize gRPC calls by grpcScopes.
+// Methods not listed need the admin scope.
+func grpcAuthUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := authorizeGRPC(ctx, grpcMethodScope(info.FullMethod)); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func grpcAuthStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authorizeGRPC(ss.Context(), grpcMethodScope(info.FullMethod)); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+func grpcMethodScope(method string) string {
+	if s, ok := grpcScopes[method]; ok {
+		return s
+	}
+
+	return scopeAdmin
+}
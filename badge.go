@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// badgeMaxLabel is the longest label a badge can be given
+const badgeMaxLabel = 40
+
+// badgeColors are the badges' message colours, by state
+var badgeColors = map[string]color.RGBA{
+	"present": {0x44, 0xcc, 0x11, 0xff},
+	"away":    {0x9f, 0x9f, 0x9f, 0xff},
+	"unknown": {0xc0, 0xc0, 0xc0, 0xff},
+	"paused":  {0xdf, 0xb3, 0x17, 0xff},
+}
+
+// badgeLabelColor is the colour of the badges' label
+var badgeLabelColor = color.RGBA{0x55, 0x55, 0x55, 0xff}
+
+// badge is a small image of the presence state, like "presence | away, seen
+// 5m ago", to embed in status pages.
+type badge struct {
+	Label   string
+	Message string
+	color   color.RGBA
+}
+
+func newBadge(r *http.Request, st statusResponse, now time.Time) badge {
+	b := badge{Label: "presence", Message: st.State, color: badgeColors[st.State]}
+
+	if l := r.URL.Query().Get("label"); l != "" {
+		b.Label = truncate(l, badgeMaxLabel)
+	}
+
+	if st.State == "away" && !st.LastSeen.IsZero() {
+		b.Message += ", seen " + roughAgo(now.Sub(st.LastSeen))
+	}
+
+	if b.color == (color.RGBA{}) {
+		b.color = badgeColors["unknown"]
+	}
+
+	return b
+}
+
+// roughAgo describes a duration coarsely, like "5m ago".
+func roughAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+
+	return s
+}
+
+// badgeTextWidth estimates the width of text in the SVG badge's 11px font.
+func badgeTextWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+var badgeTemplate = template.Must(template.New("badge").Parse(
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="{{.LabelColor}}"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.MessageX}}" y="14">{{.Message}}</text>
+</g>
+</svg>
+`))
+
+// svg renders the badge, in the style of shields.io badges.
+func (b badge) svg(w http.ResponseWriter) error {
+	lw, mw := badgeTextWidth(b.Label), badgeTextWidth(b.Message)
+	hex := func(c color.RGBA) string { return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B) }
+
+	return badgeTemplate.Execute(w, map[string]any{
+		"Label":        b.Label,
+		"Message":      b.Message,
+		"Width":        lw + mw,
+		"LabelWidth":   lw,
+		"MessageWidth": mw,
+		"LabelX":       lw / 2,
+		"MessageX":     lw + mw/2,
+		"LabelColor":   hex(badgeLabelColor),
+		"Color":        hex(b.color),
+	})
+}
+
+// png renders the badge as a PNG, for pages which don't allow SVG.
+func (b badge) png() ([]byte, error) {
+	const (
+		height = 20
+		scale  = 0.4
+		pad    = 6
+	)
+
+	lsize := gocv.GetTextSize(b.Label, font, scale, 1)
+	msize := gocv.GetTextSize(b.Message, font, scale, 1)
+	lw, mw := lsize.X+2*pad, msize.X+2*pad
+
+	img := gocv.NewMatWithSize(height, lw+mw, gocv.MatTypeCV8UC3)
+	defer img.Close()
+
+	gocv.Rectangle(&img, image.Rect(0, 0, lw, height), badgeLabelColor, -1)
+	gocv.Rectangle(&img, image.Rect(lw, 0, lw+mw, height), b.color, -1)
+
+	white := color.RGBA{255, 255, 255, 0}
+	baseline := (height + lsize.Y) / 2
+	gocv.PutText(&img, b.Label, image.Pt(pad, baseline), font, scale, white, 1)
+	gocv.PutText(&img, b.Message, image.Pt(lw+pad, baseline), font, scale, white, 1)
+
+	buf, err := gocv.IMEncode(".png", img)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+
+	return append([]byte(nil), buf.GetBytes()...), nil
+}
+
+// handleBadgeSVG and handleBadgePNG serve a badge of the presence state. The
+// badge's "seen ... ago" changes without the status changing, so it's never
+// cached.
+func handleBadgeSVG(w http.ResponseWriter, r *http.Request) {
+	b := newBadge(r, currentStatus(), time.Now())
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	if err := b.svg(w); err != nil {
+		slog.Error("rendering badge", "err", err)
+	}
+}
+
+func handleBadgePNG(w http.ResponseWriter, r *http.Request) {
+	b := newBadge(r, currentStatus(), time.Now())
+
+	img, err := b.png()
+	if err != nil {
+		slog.Error("rendering badge", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	_, _ = w.Write(img)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// imageExts are the file extensions read as still images by bench - other
+// files are read as videos
+var imageExts = []string{".jpg", ".jpeg", ".png", ".bmp", ".webp", ".tif", ".tiff"}
+
+// runBench implements the bench subcommand, which runs each enabled face
+// classifier on its own, and then the whole detection pipeline, over sample
+// images and videos, and reports per-frame latencies and resource usage, like:
+//
+//	presence bench -classifiers=haar,profile samples/
+func runBench(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cfg := detectorConfig{Classifiers: defaultClassifiers(defaultCascadesDir()), faceSize: defaultFaceSize}
+	cfg.registerFlags(fs)
+	maskCfg.registerFlags(fs)
+	exprCfg.registerFlags(fs)
+	maxFrames := fs.Int("frames", 300, "maximum number of frames to read from each video")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] image|video|directory...\n", os.Args[0], cmd)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := cfg.faceSize.validate(); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no samples given")
+	}
+
+	cfg.MaskModel, cfg.ExpressionModel = maskCfg.model, exprCfg.model
+
+	frames, err := readSamples(fs.Args(), *maxFrames)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames read from the samples")
+	}
+
+	d, err := loadDetector(cfg)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+
+	fmt.Printf("Benchmarking %d frames\n\n", len(frames))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "backend\tfaces\tp50\tp90\tp99\tmax\tCPU\tpeak RSS\t")
+
+	// each face classifier on its own, without eyes or models...
+	for _, c := range d.faces {
+		det = &detector{faces: []*cascade{c}}
+		benchBackend(tw, c.Name, frames)
+	}
+
+	// ...then everything together
+	det = d
+	benchBackend(tw, "pipeline", frames)
+
+	det = &detector{}
+
+	return tw.Flush()
+}
+
+// benchBackend runs the current detector over the frames, and writes a row of
+// results.
+func benchBackend(tw *tabwriter.Writer, name string, frames []gocv.Mat) {
+	latencies := make([]time.Duration, 0, len(frames))
+	faces := 0
+
+	cpuStart := cpuTime()
+	wallStart := time.Now()
+
+	for _, f := range frames {
+		// detection annotates the frame, so work on a copy
+		img := f.Clone()
+
+		start := time.Now()
+		faces += len(detectFaces(&img))
+		latencies = append(latencies, time.Since(start))
+
+		img.Close()
+	}
+
+	wall := time.Since(wallStart)
+	cpu := cpuTime() - cpuStart
+
+	slices.Sort(latencies)
+
+	cpuPct := "-"
+	if cpuStart >= 0 && wall > 0 {
+		cpuPct = fmt.Sprintf("%.0f%%", 100*cpu.Seconds()/wall.Seconds())
+	}
+
+	rss := "-"
+	if b := peakRSS(); b > 0 {
+		rss = fmt.Sprintf("%.0f MiB", float64(b)/(1<<20))
+	}
+
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name, faces,
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+		latencies[len(latencies)-1].Round(time.Microsecond), cpuPct, rss)
+}
+
+// percentile returns the pth percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+
+	return sorted[max(i, 0)].Round(time.Microsecond)
+}
+
+// readSamples reads frames from the given images and videos, and the images
+// and videos in the given directories.
+func readSamples(paths []string, maxFrames int) ([]gocv.Mat, error) {
+	frames := []gocv.Mat{}
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			if slices.Contains(imageExts, strings.ToLower(filepath.Ext(path))) {
+				img := gocv.IMRead(path, gocv.IMReadColor)
+				if img.Empty() {
+					return fmt.Errorf("reading image %s", path)
+				}
+
+				frames = append(frames, img)
+
+				return nil
+			}
+
+			vc, err := gocv.OpenVideoCapture(path)
+			if err != nil {
+				return fmt.Errorf("opening video %s: %w", path, err)
+			}
+			defer vc.Close()
+
+			for n := 0; n < maxFrames; n++ {
+				img := gocv.NewMat()
+				if ok := vc.Read(&img); !ok || img.Empty() {
+					img.Close()
+					break
+				}
+
+				frames = append(frames, img)
+			}
+
+			return nil
+		})
+		if err != nil {
+			for _, f := range frames {
+				f.Close()
+			}
+
+			return nil, err
+		}
+	}
+
+	return frames, nil
+}
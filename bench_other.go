@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// cpuTime isn't supported on this platform.
+func cpuTime() time.Duration {
+	return -1
+}
+
+// peakRSS isn't supported on this platform.
+func peakRSS() int64 {
+	return 0
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// cpuTime returns the CPU time (user and system) used by the process so far.
+func cpuTime() time.Duration {
+	ru := syscall.Rusage{}
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return -1
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// peakRSS returns the process's peak resident set size, in bytes.
+func peakRSS() int64 {
+	ru := syscall.Rusage{}
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+
+	// macOS reports bytes, and everything else kilobytes
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+
+	return int64(ru.Maxrss) * 1024
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// bleConfig holds the settings for Bluetooth LE proximity detection.
+type bleConfig struct {
+	devices stringsFlag
+	minRSSI int
+	timeout time.Duration
+	weight  float64
+}
+
+func (c *bleConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Var(&c.devices, "ble-device", "address or advertised name of a Bluetooth LE device (phone, watch) which indicates presence; may be repeated")
+	fs.IntVar(&c.minRSSI, "ble-rssi", -75, "minimum signal strength (dBm) for a BLE device to count as nearby")
+	fs.DurationVar(&c.timeout, "ble-timeout", 2*time.Minute, "freshness window: how long a BLE device counts as nearby after its last advertisement")
+	fs.Float64Var(&c.weight, "ble-weight", 1, "weight of BLE proximity in the fusion policy")
+}
+
+func (c bleConfig) enabled() bool {
+	return len(c.devices) > 0
+}
+
+// bleScanner listens for advertisements from the configured devices, and
+// reports presence while any of them has been seen nearby recently.
+type bleScanner struct {
+	cfg     bleConfig
+	devices map[string]bool
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+func newBLEScanner(cfg bleConfig) *bleScanner {
+	s := &bleScanner{cfg: cfg, devices: map[string]bool{}}
+	for _, d := range cfg.devices {
+		s.devices[strings.ToLower(d)] = true
+	}
+
+	return s
+}
+
+// scan enables the default adapter and scans until an error occurs. Scanning
+// is passive, so it doesn't need the devices to be paired.
+func (s *bleScanner) scan() error {
+	adapter := bluetooth.DefaultAdapter
+	if err := adapter.Enable(); err != nil {
+		return fmt.Errorf("enabling Bluetooth adapter: %w", err)
+	}
+
+	slog.Info("Scanning for Bluetooth LE devices", "devices", s.cfg.devices)
+
+	return adapter.Scan(func(_ *bluetooth.Adapter, r bluetooth.ScanResult) {
+		if int(r.RSSI) < s.cfg.minRSSI {
+			return
+		}
+
+		name := r.LocalName()
+		if !s.devices[strings.ToLower(r.Address.String())] && (name == "" || !s.devices[strings.ToLower(name)]) {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.lastSeen = time.Now()
+	})
+}
+
+func (s *bleScanner) lastActive() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastSeen
+}
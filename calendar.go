@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// history records when presence arrived and departed, for the calendar feed.
+var history *presenceHistory
+
+// historyConfig holds the presence history settings.
+type historyConfig struct {
+	file string
+	days int
+}
+
+func (c *historyConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "history", "", "JSONL file to keep the history of arrivals and departures in, so /presence.ics survives restarts (default kept in memory)")
+	fs.IntVar(&c.days, "history-days", 30, "days of presence history to keep")
+}
+
+func (c historyConfig) validate() error {
+	if c.days <= 0 {
+		return fmt.Errorf("invalid -history-days %d: must be positive", c.days)
+	}
+
+	return nil
+}
+
+// historyEntry is an arrival or departure.
+type historyEntry struct {
+	Type eventType `json:"type"`
+	Time time.Time `json:"time"`
+	// LastSeen is when presence was last seen - for departures, earlier than
+	// Time by the away timeout
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// presenceInterval is a time presence was seen, from arrival until it was
+// last seen before departing.
+type presenceInterval struct {
+	Start, End time.Time
+	// Ongoing is true while still present, in which case End is now
+	Ongoing bool
+}
+
+// presenceHistory keeps the arrivals and departures of the last -history-days,
+// optionally persisted to a file. It's a notifier of the whole frame's events.
+type presenceHistory struct {
+	cfg historyConfig
+
+	mu      sync.Mutex
+	entries []historyEntry
+	f       *os.File
+}
+
+func newPresenceHistory(cfg historyConfig) (*presenceHistory, error) {
+	h := &presenceHistory{cfg: cfg}
+	if cfg.file == "" {
+		return h, nil
+	}
+
+	if err := h.load(time.Now()); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(cfg.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	h.f = f
+
+	return h, nil
+}
+
+// load reads the history file, rewriting it without entries older than
+// -history-days.
+func (h *presenceHistory) load(now time.Time) error {
+	f, err := os.Open(h.cfg.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	total := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		total++
+
+		e := historyEntry{}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("parsing %s line %d: %w", h.cfg.file, total, err)
+		}
+		h.entries = append(h.entries, e)
+	}
+
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", h.cfg.file, err)
+	}
+
+	h.prune(now)
+	if len(h.entries) == total {
+		return nil
+	}
+
+	b := []byte{}
+	for _, e := range h.entries {
+		line, _ := json.Marshal(e)
+		b = append(append(b, line...), '\n')
+	}
+
+	return writeFileAtomic(h.cfg.file, b)
+}
+
+// prune forgets entries older than -history-days. Callers must hold h.mu,
+// except while loading.
+func (h *presenceHistory) prune(now time.Time) {
+	cutoff := now.AddDate(0, 0, -h.cfg.days)
+
+	i := 0
+	for i < len(h.entries) && h.entries[i].Time.Before(cutoff) {
+		i++
+	}
+
+	h.entries = h.entries[i:]
+}
+
+func (h *presenceHistory) notify(_ context.Context, ev event) error {
+	if ev.Zone != "" {
+		return nil
+	}
+
+	return h.add(historyEntry{Type: ev.Type, Time: ev.Time, LastSeen: ev.LastSeen})
+}
+
+func (h *presenceHistory) add(e historyEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, e)
+	h.prune(time.Now())
+
+	if h.f == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("writing presence history: %w", err)
+	}
+
+	return nil
+}
+
+// close ends any ongoing presence at when it was last seen, since whether it
+// continued while stopped isn't known, and closes the file.
+func (h *presenceHistory) close(st presenceStatus) error {
+	if st.Known && st.Present {
+		if err := h.add(historyEntry{Type: eventDeparture, Time: time.Now(), LastSeen: st.LastSeen}); err != nil {
+			slog.Error("recording presence history", "err", err)
+		}
+	}
+
+	if h.f == nil {
+		return nil
+	}
+
+	return h.f.Close()
+}
+
+// intervals returns the times presence was seen since the given time, oldest
+// first. Arrivals whose departure wasn't recorded (like when stopped
+// abruptly) are left out, as their end isn't known - except for the current
+// one, which ends now.
+func (h *presenceHistory) intervals(since, now time.Time, present bool) []presenceInterval {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := []presenceInterval{}
+
+	var start time.Time
+	for _, e := range h.entries {
+		switch e.Type {
+		case eventArrival:
+			start = e.Time
+		case eventDeparture:
+			if start.IsZero() {
+				continue
+			}
+
+			end := e.LastSeen
+			if end.Before(start) {
+				end = start
+			}
+
+			if end.After(since) {
+				out = append(out, presenceInterval{Start: start, End: end})
+			}
+			start = time.Time{}
+		}
+	}
+
+	if !start.IsZero() && present {
+		out = append(out, presenceInterval{Start: start, End: now, Ongoing: true})
+	}
+
+	return out
+}
+
+// calendarRefresh is how often calendar clients are asked to refresh the feed
+const calendarRefresh = 15 * time.Minute
+
+// handleCalendar serves the presence history as an iCalendar feed, with each
+// time presence was seen as a busy event.
+func handleCalendar(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	days := history.cfg.days
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid days %q: must be a positive number", v), http.StatusBadRequest)
+			return
+		}
+		days = min(n, days)
+	}
+
+	summary := q.Get("summary")
+	if summary == "" {
+		summary = "Present"
+	}
+
+	now := time.Now()
+	st := tracker.status()
+	intervals := history.intervals(now.AddDate(0, 0, -days), now, st.Known && st.Present)
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeICS(w, st.Camera, summary, intervals, now)
+}
+
+// writeICS writes the intervals as an iCalendar (RFC 5545) calendar.
+func writeICS(w http.ResponseWriter, camera, summary string, intervals []presenceInterval, now time.Time) {
+	const stamp = "20060102T150405Z"
+
+	b := &strings.Builder{}
+	line := func(s string) { icsLine(b, s) }
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//hairyhenderson//presence//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + icsText("presence ("+camera+")"))
+	line("REFRESH-INTERVAL;VALUE=DURATION:PT" + strconv.Itoa(int(calendarRefresh.Minutes())) + "M")
+	line("X-PUBLISHED-TTL:PT" + strconv.Itoa(int(calendarRefresh.Minutes())) + "M")
+
+	for _, iv := range intervals {
+		desc := "Presence seen by camera " + camera
+		if iv.Ongoing {
+			desc += " - ongoing"
+		}
+
+		line("BEGIN:VEVENT")
+		// the start identifies the event, so it's stable as an ongoing
+		// interval grows
+		line("UID:" + strconv.FormatInt(iv.Start.UnixNano(), 36) + "-" + icsText(camera) + "@presence")
+		line("DTSTAMP:" + now.UTC().Format(stamp))
+		line("DTSTART:" + iv.Start.UTC().Format(stamp))
+		line("DTEND:" + iv.End.UTC().Format(stamp))
+		line("SUMMARY:" + icsText(summary))
+		line("DESCRIPTION:" + icsText(desc))
+		line("TRANSP:OPAQUE")
+		line("X-MICROSOFT-CDO-BUSYSTATUS:BUSY")
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// icsText escapes a TEXT value.
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsLine writes a content line, folded to 75 octets as RFC 5545 requires,
+// without splitting UTF-8 sequences. Invalid UTF-8 is replaced first, so a
+// fold always finds a rune start within a few octets.
+func icsLine(b *strings.Builder, s string) {
+	const limit = 75
+
+	s = strings.ToValidUTF8(s, "\uFFFD")
+
+	width := limit
+	for len(s) > width {
+		cut := width
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+
+		// continuation lines start with a space
+		width = limit - 1
+	}
+
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestICSText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Present", "Present"},
+		{"desk, office; upstairs", `desk\, office\; upstairs`},
+		{`C:\camera`, `C:\\camera`},
+		{"two\nlines", `two\nlines`},
+		{"two\r\nlines", `two\nlines`},
+	}
+
+	for _, tt := range tests {
+		if got := icsText(tt.in); got != tt.want {
+			t.Errorf("icsText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestICSLine(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		// want is the folded line, or "" to only check it unfolds to in (with
+		// invalid UTF-8 replaced)
+		want string
+	}{
+		{name: "short", in: "BEGIN:VCALENDAR", want: "BEGIN:VCALENDAR\r\n"},
+		{name: "empty", in: "", want: "\r\n"},
+		{name: "75 octets", in: strings.Repeat("a", 75), want: strings.Repeat("a", 75) + "\r\n"},
+		{name: "76 octets", in: strings.Repeat("a", 76), want: strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{
+			name: "several folds", in: strings.Repeat("a", 75+74+10),
+			want: strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n " + strings.Repeat("a", 10) + "\r\n",
+		},
+		// folds mustn't split multi-byte characters
+		{name: "two-byte runes", in: "SUMMARY:" + strings.Repeat("é", 80)},
+		{name: "four-byte runes", in: "SUMMARY:" + strings.Repeat("🪑", 50)},
+		{name: "invalid", in: "SUMMARY:\xff\xfe" + strings.Repeat("a", 80)},
+		{name: "continuation bytes only", in: strings.Repeat("\x80", 101), want: "\uFFFD\r\n"},
+		{name: "continuation bytes after text", in: "SUMMARY:" + strings.Repeat("\xbf", 200) + "é"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			icsLine(b, tt.in)
+			got := b.String()
+
+			if tt.want != "" && got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			if !strings.HasSuffix(got, "\r\n") {
+				t.Fatalf("%q doesn't end with CRLF", got)
+			}
+
+			for _, l := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+				if len(l) > 75 {
+					t.Errorf("line %q is %d octets, more than 75", l, len(l))
+				}
+
+				if !utf8.ValidString(l) {
+					t.Errorf("line %q splits a character", l)
+				}
+			}
+
+			want := strings.ToValidUTF8(tt.in, "\uFFFD")
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(got, "\r\n"), "\r\n ", ""); unfolded != want {
+				t.Errorf("unfolds to %q, want %q", unfolded, want)
+			}
+		})
+	}
+}
+
+func TestHistoryIntervals(t *testing.T) {
+	t0 := time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return t0.Add(time.Duration(m) * time.Minute) }
+	arrive := func(m int) historyEntry {
+		return historyEntry{Type: eventArrival, Time: at(m), LastSeen: at(m)}
+	}
+	depart := func(m, seen int) historyEntry {
+		return historyEntry{Type: eventDeparture, Time: at(m), LastSeen: at(seen)}
+	}
+
+	tests := []struct {
+		name    string
+		entries []historyEntry
+		since   int
+		present bool
+		want    []presenceInterval
+	}{
+		{name: "none", want: []presenceInterval{}},
+		{
+			name:    "ends when last seen",
+			entries: []historyEntry{arrive(0), depart(40, 30)},
+			want:    []presenceInterval{{Start: at(0), End: at(30)}},
+		},
+		{
+			name:    "ongoing",
+			entries: []historyEntry{arrive(0), depart(40, 30), arrive(60)},
+			present: true,
+			want:    []presenceInterval{{Start: at(0), End: at(30)}, {Start: at(60), End: at(90), Ongoing: true}},
+		},
+		{
+			name:    "departure not recorded",
+			entries: []historyEntry{arrive(0), arrive(60), depart(80, 70)},
+			want:    []presenceInterval{{Start: at(60), End: at(70)}},
+		},
+		{
+			name:    "departure without arrival",
+			entries: []historyEntry{depart(10, 5), arrive(20), depart(40, 30)},
+			want:    []presenceInterval{{Start: at(20), End: at(30)}},
+		},
+		{
+			name:    "before since",
+			entries: []historyEntry{arrive(0), depart(20, 10), arrive(30), depart(50, 45)},
+			since:   15,
+			want:    []presenceInterval{{Start: at(30), End: at(45)}},
+		},
+		{
+			name:    "arrival not yet departed, but not present",
+			entries: []historyEntry{arrive(0)},
+			want:    []presenceInterval{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &presenceHistory{cfg: historyConfig{days: 30}, entries: tt.entries}
+
+			got := h.intervals(at(tt.since), at(90), tt.present)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) || got[i].Ongoing != tt.want[i].Ongoing {
+					t.Errorf("interval %d is %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
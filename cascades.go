@@ -0,0 +1,390 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// embeddedCascades are built in, so no OpenCV data files need to be installed
+// (e.g. in a container). Only the Haar face classifier, which detection needs,
+// is embedded.
+//
+//go:embed cascades
+var embeddedCascades embed.FS
+
+// homebrewCascadesDir is where Homebrew's OpenCV keeps its cascades
+const homebrewCascadesDir = "/opt/homebrew/Cellar/opencv/4.9.0_4/share/opencv4"
+
+// cascade files, relative to the cascades directory
+const (
+	haarFaceCascadeFile = "haarcascades/haarcascade_frontalface_default.xml"
+	profileCascadeFile  = "haarcascades/haarcascade_profileface.xml"
+	eyeCascadeFile      = "haarcascades/haarcascade_eye.xml"
+	lbpFaceCascadeFile  = "lbpcascades/lbpcascade_frontalface_improved.xml"
+)
+
+// cascade is a cascade classifier, and how its detections are handled.
+// Besides the built-in classifiers, more can be listed in a -cascades-config
+// JSON file, like:
+//
+//	[{"name": "profile", "file": "haarcascades/haarcascade_profileface.xml", "color": "#ffff00", "minFraction": 0.05, "faces": true}]
+type cascade struct {
+	Name string `json:"name"`
+	// File is the classifier's XML file - relative paths are resolved
+	// against -cascades, or the config file's directory
+	File string `json:"file"`
+	// Color is the annotation color, like "#00ff00"
+	Color string `json:"color"`
+	// MinFraction and MaxFraction bound the width of detections, exclusively,
+	// as fractions of the frame's width (0 for no bound)
+	MinFraction float64 `json:"minFraction"`
+	MaxFraction float64 `json:"maxFraction"`
+	// MinSize and MaxSize bound the width of detections in pixels, overriding
+	// the fractions (0 to use the fractions)
+	MinSize int `json:"minSize"`
+	MaxSize int `json:"maxSize"`
+	// Faces is whether detections count as faces, for presence - otherwise
+	// they're only annotated
+	Faces bool `json:"faces"`
+	// Mirror is whether to also run the classifier on the horizontally
+	// mirrored frame, for classifiers which only detect one orientation, like
+	// profile faces
+	Mirror bool `json:"mirror"`
+
+	classifier gocv.CascadeClassifier
+	rgba       color.RGBA
+	smoother   boxSmoother
+}
+
+// fits reports whether a detection is within the size bounds, in a frame of
+// the given width.
+func (c *cascade) fits(r image.Rectangle, frameWidth int) bool {
+	minSize, maxSize := c.MinSize, c.MaxSize
+	if minSize <= 0 {
+		minSize = int(c.MinFraction * float64(frameWidth))
+	}
+	if maxSize <= 0 {
+		maxSize = int(c.MaxFraction * float64(frameWidth))
+	}
+
+	w := r.Dx()
+
+	return (minSize <= 0 || w > minSize) && (maxSize <= 0 || w < maxSize)
+}
+
+// detect returns the detections within the size bounds, in gray and, when
+// mirrored, in its mirror image. mirrored is gray flipped horizontally, or
+// empty when no classifier is mirrored. Detections are smoothed with the
+// previous frame's before they're checked against the bounds.
+func (c *cascade) detect(gray, mirrored gocv.Mat) []image.Rectangle {
+	raw := c.classifier.DetectMultiScale(gray)
+
+	if c.Mirror {
+		w := gray.Cols()
+		for _, r := range c.classifier.DetectMultiScale(mirrored) {
+			raw = append(raw, image.Rect(w-r.Max.X, r.Min.Y, w-r.Min.X, r.Max.Y))
+		}
+	}
+
+	found := []image.Rectangle{}
+	for _, r := range c.smoother.smooth(raw, boxSmoothing) {
+		if c.fits(r, gray.Cols()) {
+			found = append(found, r)
+		}
+	}
+
+	return found
+}
+
+func (c *cascade) load(path string) error {
+	rgba, err := parseColor(c.Color)
+	if err != nil {
+		return fmt.Errorf("classifier %s: %w", c.Name, err)
+	}
+	c.rgba = rgba
+
+	c.classifier = gocv.NewCascadeClassifier()
+	if !c.classifier.Load(path) {
+		c.classifier.Close()
+		return fmt.Errorf("loading classifier %s from %s", c.Name, path)
+	}
+
+	return nil
+}
+
+// builtinCascades returns the built-in classifiers, by name. Only the Haar
+// frontal face classifier is embedded, and the LBP face classifier is
+// annotated without counting as faces. The profile classifier only detects
+// faces turned to one side, so it's mirrored to find the other.
+func builtinCascades(size faceSizeConfig) map[string]*cascade {
+	return map[string]*cascade{
+		"haar": {
+			Name: "haar", File: haarFaceCascadeFile, Color: "#00ff00", Faces: true,
+			MinFraction: size.min, MaxFraction: size.max, MinSize: size.minPx, MaxSize: size.maxPx,
+		},
+		"profile": {
+			Name: "profile", File: profileCascadeFile, Color: "#00ffff", Faces: true, Mirror: true,
+			MinFraction: size.min, MaxFraction: size.max, MinSize: size.minPx, MaxSize: size.maxPx,
+		},
+		"lbp": {Name: "lbp", File: lbpFaceCascadeFile, Color: "#ff0000"},
+		"eye": {Name: "eye", File: eyeCascadeFile, Color: "#0000ff"},
+	}
+}
+
+// defaultFaceSize bounds the size of faces found by the built-in face
+// classifiers. The defaults make sense on my Apple Studio Display's webcam, but
+// may need adjustment for other webcams, or rooms.
+var defaultFaceSize = faceSizeConfig{min: 0.1, max: 0.3}
+
+// faceSizeConfig bounds the width of faces, as fractions of the frame's width
+// so they don't need recalibrating between cameras of different resolutions,
+// or optionally in pixels.
+type faceSizeConfig struct {
+	min, max     float64
+	minPx, maxPx int
+}
+
+func (c *faceSizeConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&c.min, "min-face", c.min, "minimum width of a face, as a fraction of the frame's width (0 for no minimum)")
+	fs.Float64Var(&c.max, "max-face", c.max, "maximum width of a face, as a fraction of the frame's width (0 for no maximum)")
+	fs.IntVar(&c.minPx, "min-face-px", 0, "minimum width of a face in pixels, overriding -min-face")
+	fs.IntVar(&c.maxPx, "max-face-px", 0, "maximum width of a face in pixels, overriding -max-face")
+}
+
+func (c faceSizeConfig) validate() error {
+	if c.min < 0 || c.min >= 1 || c.max < 0 || c.max > 1 {
+		return fmt.Errorf("invalid -min-face %v or -max-face %v: must be fractions of the frame's width", c.min, c.max)
+	}
+
+	if c.minPx < 0 || c.maxPx < 0 {
+		return fmt.Errorf("invalid -min-face-px %d or -max-face-px %d: must not be negative", c.minPx, c.maxPx)
+	}
+
+	return nil
+}
+
+// defaultCascadesDir returns Homebrew's cascades directory when it exists,
+// and otherwise "", for the embedded cascades.
+func defaultCascadesDir() string {
+	if _, err := os.Stat(filepath.Join(homebrewCascadesDir, haarFaceCascadeFile)); err == nil {
+		return homebrewCascadesDir
+	}
+
+	return ""
+}
+
+// defaultClassifiers returns the built-in classifiers enabled by default when
+// loading from dir - all of them from an OpenCV data directory, but only the
+// Haar face classifier from the embedded cascades, as it's the only one
+// embedded.
+func defaultClassifiers(dir string) string {
+	if dir == "" {
+		return "haar"
+	}
+
+	return "haar,profile,lbp,eye"
+}
+
+// loadCascades loads the comma-separated enabled built-in classifiers from
+// dir, or from the embedded cascades when dir is empty, followed by any listed
+// in configFile. Every enabled classifier is required, so a missing one isn't
+// mistaken for nothing being found. The built-in face classifiers find faces
+// within size. It returns the face classifiers, in order, and the eye
+// classifier, if loaded.
+func loadCascades(dir, enabled, configFile string, size faceSizeConfig) ([]*cascade, *cascade, error) {
+	custom, err := readCascadesConfig(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	builtin := builtinCascades(size)
+	names := map[string]bool{}
+	for _, n := range strings.Split(enabled, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+
+		if builtin[n] == nil {
+			return nil, nil, fmt.Errorf("unknown classifier %q: must be haar, profile, lbp, or eye", n)
+		}
+		names[n] = true
+	}
+
+	customDir := dir
+	if customDir == "" {
+		customDir = filepath.Dir(configFile)
+	}
+
+	embedded := dir == ""
+	if embedded {
+		tmp, err := extractCascades()
+		if err != nil {
+			return nil, nil, fmt.Errorf("extracting embedded cascades: %w", err)
+		}
+		// the classifiers are read into memory, so the files aren't needed
+		// once loaded
+		defer os.RemoveAll(tmp)
+
+		dir = tmp
+	}
+
+	loaded := []*cascade{}
+	closeAll := func() {
+		for _, c := range loaded {
+			c.classifier.Close()
+		}
+	}
+
+	var (
+		faceCascades []*cascade
+		eyeCascade   *cascade
+	)
+
+	for _, n := range []string{"haar", "profile", "lbp", "eye"} {
+		if !names[n] {
+			continue
+		}
+
+		c := builtin[n]
+		if col, ok := annotation.colors[n]; ok {
+			c.Color = col
+		}
+		path := filepath.Join(dir, c.File)
+
+		if _, err := os.Stat(path); err != nil && embedded {
+			closeAll()
+			return nil, nil, fmt.Errorf("classifier %s isn't built in: pass -cascades with an OpenCV data directory, or leave it out of -classifiers", n)
+		}
+
+		if err := c.load(path); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		loaded = append(loaded, c)
+
+		if n == "eye" {
+			eyeCascade = c
+		} else {
+			faceCascades = append(faceCascades, c)
+		}
+	}
+
+	for _, c := range custom {
+		if col, ok := annotation.colors[c.Name]; ok {
+			c.Color = col
+		}
+
+		path := c.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(customDir, path)
+		}
+
+		if err := c.load(path); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		loaded = append(loaded, c)
+
+		faceCascades = append(faceCascades, c)
+	}
+
+	if !slices.ContainsFunc(faceCascades, func(c *cascade) bool { return c.Faces }) {
+		slog.Warn("No enabled classifier counts faces, so presence will never be detected by the camera")
+	}
+
+	return faceCascades, eyeCascade, nil
+}
+
+// readCascadesConfig reads a JSON array of additional classifiers, if path
+// isn't empty.
+func readCascadesConfig(path string) ([]*cascade, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading cascades config: %w", err)
+	}
+
+	cascades := []*cascade{}
+	if err := json.Unmarshal(b, &cascades); err != nil {
+		return nil, fmt.Errorf("parsing cascades config %s: %w", path, err)
+	}
+
+	for i, c := range cascades {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("cascade-%d", i)
+		}
+
+		if c.File == "" {
+			return nil, fmt.Errorf("classifier %s: missing file", c.Name)
+		}
+
+		if c.Color == "" {
+			c.Color = "#ffff00"
+		}
+	}
+
+	return cascades, nil
+}
+
+// parseColor parses a color like "#00ff00".
+func parseColor(s string) (color.RGBA, error) {
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil || len(s) != 7 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: must be like #00ff00", s)
+	}
+
+	return color.RGBA{r, g, b, 0}, nil
+}
+
+// extractCascades writes the embedded cascades to a temporary directory, as
+// OpenCV can only load them from files.
+func extractCascades() (string, error) {
+	tmp, err := os.MkdirTemp("", "presence-cascades-")
+	if err != nil {
+		return "", err
+	}
+
+	err = fs.WalkDir(embeddedCascades, "cascades", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel("cascades", path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(tmp, rel), 0o755)
+		}
+
+		b, err := embeddedCascades.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(filepath.Join(tmp, rel), b, 0o644)
+	})
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", err
+	}
+
+	return tmp, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// faceClassifier runs a DNN image classification model on face regions, like
+// the mask and expression classifiers. Callers must hold webcamMu.
+type faceClassifier struct {
+	net  gocv.Net
+	size int
+	gray bool
+}
+
+func newFaceClassifier(model, config string, size int, gray bool) (*faceClassifier, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid input size %d: must be positive", size)
+	}
+
+	net := gocv.ReadNet(model, config)
+	if net.Empty() {
+		return nil, fmt.Errorf("loading model %s", model)
+	}
+
+	return &faceClassifier{net: net, size: size, gray: gray}, nil
+}
+
+// classify returns the model's outputs (usually class probabilities) for the
+// given region of img.
+func (c *faceClassifier) classify(img gocv.Mat, r image.Rectangle) []float64 {
+	roi := img.Region(r)
+	defer roi.Close()
+
+	in := roi
+	if c.gray {
+		gray := gocv.NewMat()
+		defer gray.Close()
+		gocv.CvtColor(roi, &gray, gocv.ColorBGRToGray)
+
+		in = gray
+	}
+
+	// most classifiers are trained on RGB images with values scaled to 0-1
+	blob := gocv.BlobFromImage(in, 1.0/255, image.Pt(c.size, c.size), gocv.NewScalar(0, 0, 0, 0), !c.gray, false)
+	defer blob.Close()
+
+	c.net.SetInput(blob, "")
+
+	out := c.net.Forward("")
+	defer out.Close()
+
+	probs := make([]float64, out.Total())
+	for i := range probs {
+		probs[i] = float64(out.GetFloatAt(0, i))
+	}
+
+	return probs
+}
+
+func (c *faceClassifier) close() {
+	_ = c.net.Close()
+}
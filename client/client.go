@@ -0,0 +1,254 @@
+// Package client is a Go client for the presence HTTP API.
+//
+//	c, err := client.NewClient("http://127.0.0.1:8888")
+//	if err != nil {
+//		return err
+//	}
+//
+//	st, err := c.Status(ctx)
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a presence daemon's HTTP API.
+type Client struct {
+	baseURL *url.URL
+	hc      *http.Client
+	apiKey  string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests. Note that event
+// subscriptions are long-lived, so the client shouldn't have a Timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithAPIKey sets the API key sent with requests, for daemons which require
+// them.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// NewClient creates a client for the presence daemon at baseURL, e.g.
+// "http://127.0.0.1:8888".
+func NewClient(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{baseURL: u, hc: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// Status is the daemon's current presence state.
+type Status struct {
+	// State is "present", "away", "unknown", or "paused"
+	State    string    `json:"state"`
+	Known    bool      `json:"known"`
+	Present  bool      `json:"present"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"lastSeen"`
+	Faces    int       `json:"faces"`
+	Camera   string    `json:"camera"`
+	// Paused is true when capture has been paused through the API
+	Paused bool `json:"paused"`
+	// Scheduled is false when outside of the configured schedule
+	Scheduled bool `json:"scheduled"`
+}
+
+// Signals describes each of the signals combined into the presence decision.
+type Signals struct {
+	Signals   []Signal `json:"signals"`
+	Score     float64  `json:"score"`
+	Threshold float64  `json:"threshold"`
+	Present   bool     `json:"present"`
+}
+
+// Signal is a single presence signal, such as the camera or a nearby phone.
+type Signal struct {
+	Name       string    `json:"name"`
+	Active     bool      `json:"active"`
+	LastActive time.Time `json:"lastActive"`
+	Weight     float64   `json:"weight"`
+	// Freshness is the signal's freshness window, in seconds
+	Freshness float64 `json:"freshness"`
+}
+
+// Event is a change in presence state.
+type Event struct {
+	// Type is "arrival" or "departure"
+	Type string `json:"type"`
+	// State is the new state, "present" or "away"
+	State    string    `json:"state"`
+	Time     time.Time `json:"time"`
+	LastSeen time.Time `json:"lastSeen"`
+	Faces    int       `json:"faces"`
+	Camera   string    `json:"camera"`
+	// Zone is the zone the event relates to, or empty for the whole frame
+	Zone string `json:"zone,omitempty"`
+}
+
+// Status returns the current presence state.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	st := &Status{}
+	if err := c.doJSON(ctx, http.MethodGet, "/status", st); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Signals returns the state of each presence signal.
+func (c *Client) Signals(ctx context.Context) (*Signals, error) {
+	s := &Signals{}
+	if err := c.doJSON(ctx, http.MethodGet, "/signals", s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Pause pauses capture, releasing the camera, and returns the new status.
+func (c *Client) Pause(ctx context.Context) (*Status, error) {
+	st := &Status{}
+	if err := c.doJSON(ctx, http.MethodPost, "/pause", st); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Resume resumes capture, and returns the new status.
+func (c *Client) Resume(ctx context.Context) (*Status, error) {
+	st := &Status{}
+	if err := c.doJSON(ctx, http.MethodPost, "/resume", st); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Snapshot returns an annotated JPEG frame. While capture is paused or
+// outside of its schedule, this is a placeholder image.
+func (c *Client) Snapshot(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	return b, nil
+}
+
+// Subscribe streams presence events until the context is cancelled or the
+// connection is lost, at which point the channel is closed.
+func (c *Client) Subscribe(ctx context.Context) (<-chan Event, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/events")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event)
+
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			data, ok := strings.CutPrefix(sc.Text(), "data: ")
+			if !ok {
+				continue
+			}
+
+			ev := Event{}
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				continue
+			}
+
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, out any) error {
+	resp, err := c.do(ctx, method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s response: %w", path, err)
+	}
+
+	return nil
+}
+
+// do sends a request, returning an error for non-2xx responses.
+func (c *Client) do(ctx context.Context, method, path string) (*http.Response, error) {
+	u := c.baseURL.JoinPath(path)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+		return nil, fmt.Errorf("%s %s: unexpected response %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
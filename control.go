@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hairyhenderson/presence/client"
+	"gocv.io/x/gocv"
+)
+
+var (
+	// paused is set when capture has been paused through the API. Guarded by
+	// webcamMu.
+	paused bool
+
+	errPaused = errors.New("capture paused")
+)
+
+// setPaused pauses or resumes capture. Pausing releases the camera
+// immediately, so the webcam's LED goes off.
+func setPaused(p bool) {
+	webcamMu.Lock()
+	defer webcamMu.Unlock()
+
+	if p == paused {
+		return
+	}
+
+	paused = p
+	if paused {
+		closeWebcam()
+		tracker.pause(time.Now())
+		slog.Info("Capture paused, camera released")
+	} else {
+		slog.Info("Capture resumed")
+	}
+}
+
+func isPaused() bool {
+	webcamMu.Lock()
+	defer webcamMu.Unlock()
+
+	return paused
+}
+
+// statusResponse is the body of the /status endpoint
+type statusResponse struct {
+	presenceStatus
+	// State is "present", "away", "unknown", or "paused"
+	State string `json:"state"`
+	// Paused is true when capture has been paused through the API
+	Paused bool `json:"paused"`
+	// Scheduled is false when outside of the configured schedule
+	Scheduled bool `json:"scheduled"`
+	// Exposure is how well exposed the camera's frames are - see
+	// exposureStatus
+	Exposure string `json:"exposure,omitempty"`
+	// Profile is the active detection profile, if any
+	Profile string `json:"profile,omitempty"`
+}
+
+func currentStatus() statusResponse {
+	st := statusResponse{
+		presenceStatus: tracker.status(),
+		Paused:         isPaused(),
+		Scheduled:      activeSchedule.active(time.Now()),
+		Exposure:       exposure.status().Condition,
+		Profile:        profiles.activeProfile(),
+	}
+
+	st.State = st.presenceStatus.State()
+	if st.Paused {
+		st.State = "paused"
+	}
+
+	return st
+}
+
+// handleStatus responds with the status as JSON, or with Accept: text/plain,
+// as just the state. Prometheus scrapes get a Prometheus exposition.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	st := currentStatus()
+	format := statusFormat(r.Header.Get("Accept"))
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// the exposition includes the signals, which aren't covered by the
+	// status revision
+	if format == formatPrometheus {
+		writeStatusPrometheus(w, st, tracker.fusion.status(time.Now()), tracker.zoneStatuses())
+		return
+	}
+
+	if notModified(w, r, statusRevisions.etag(st, "-"+format)) {
+		return
+	}
+
+	if format == formatText {
+		writeStatusText(w, st)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		slog.Error("writing status", "err", err)
+	}
+}
+
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	setPaused(true)
+	handleStatus(w, r)
+}
+
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	setPaused(false)
+	handleStatus(w, r)
+}
+
+// placeholderMessage describes why capture isn't happening, for placeholder
+// frames.
+func placeholderMessage(reason error) string {
+	if errors.Is(reason, errOutsideSchedule) {
+		return "Outside schedule"
+	}
+
+	if errors.Is(reason, errNoCamera) {
+		return "No camera"
+	}
+
+	if errors.Is(reason, errFrameQuota) {
+		return "Frame limit reached"
+	}
+
+	return "Paused"
+}
+
+// placeholderFrames renders a JPEG explaining why there's no camera image, at
+// each of the given widths.
+func placeholderFrames(msg string, widths []int) (map[int][]byte, error) {
+	img := gocv.NewMatWithSize(360, 640, gocv.MatTypeCV8UC3)
+	defer img.Close()
+
+	img.SetTo(gocv.NewScalar(40, 40, 40, 0))
+
+	size := gocv.GetTextSize(msg, font, 2.0, 2)
+	org := image.Pt((img.Cols()-size.X)/2, (img.Rows()+size.Y)/2)
+	gocv.PutText(&img, msg, org, font, 2.0, color.RGBA{200, 200, 200, 0}, 2)
+
+	return encodeJPEGs(img, widths)
+}
+
+// runControl implements the pause, resume, and toggle subcommands, which
+// control a running daemon. These are handy to bind to keyboard shortcuts.
+func runControl(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	addr := fs.String("addr", "http://127.0.0.1:8888", "base URL of the running presence daemon")
+	key := fs.String("key", "", "API key with the admin scope, for daemons with -api-keys (default $PRESENCE_API_KEY)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *key == "" {
+		*key = os.Getenv("PRESENCE_API_KEY")
+	}
+
+	c, err := client.NewClient(*addr, client.WithAPIKey(*key))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if cmd == "toggle" {
+		st, err := c.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		cmd = "pause"
+		if st.Paused {
+			cmd = "resume"
+		}
+	}
+
+	var st *client.Status
+	if cmd == "pause" {
+		st, err = c.Pause(ctx)
+	} else {
+		st, err = c.Resume(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(st.State)
+
+	return nil
+}
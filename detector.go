@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+var (
+	// det is the current detector. Guarded by webcamMu.
+	det = &detector{}
+	// detectorCfg is the current detector's configuration, initially from
+	// flags. Guarded by webcamMu once the detector is loaded.
+	detectorCfg = detectorConfig{Classifiers: defaultClassifiers(defaultCascadesDir()), faceSize: defaultFaceSize}
+)
+
+// detectorConfig selects the detection backends - the cascade classifiers,
+// and the optional DNN models. It can be changed at runtime with
+// PUT /config/detector.
+type detectorConfig struct {
+	// Classifiers are the comma-separated built-in classifiers to enable
+	Classifiers string `json:"classifiers"`
+	// CascadesDir is where to load the classifiers from, or "" for the
+	// embedded ones
+	CascadesDir string `json:"cascadesDir"`
+	// CascadesConfig is a JSON file listing additional classifiers
+	CascadesConfig string `json:"cascadesConfig"`
+	// MaskModel and ExpressionModel are the DNN models to label faces with,
+	// or "" to disable them. Their other settings come from flags.
+	MaskModel       string `json:"maskModel"`
+	ExpressionModel string `json:"expressionModel"`
+
+	// faceSize bounds the faces found by the built-in face classifiers. It's
+	// tuned with PUT /config/detection.
+	faceSize faceSizeConfig
+}
+
+// registerFlags registers the cascade and face size flags. The models are set
+// with -mask-model and -expression-model.
+func (c *detectorConfig) registerFlags(fs *flag.FlagSet) {
+	c.faceSize.registerFlags(fs)
+	fs.StringVar(&c.CascadesDir, "cascades", defaultCascadesDir(), "OpenCV data directory to load classifiers from (default the built-in classifiers)")
+	fs.StringVar(&c.Classifiers, "classifiers", c.Classifiers, "comma-separated built-in classifiers to enable: haar, profile, lbp, and eye")
+	fs.StringVar(&c.CascadesConfig, "cascades-config", "", "JSON file listing additional cascade classifiers, with their colors and size bounds")
+}
+
+// detector holds the loaded classifiers. Callers must hold webcamMu.
+type detector struct {
+	// faces are the enabled face classifiers, in order - see loadCascades
+	faces []*cascade
+	// eye finds eyes within faces, when enabled and available
+	eye *cascade
+	// mask labels faces as masked or not, when a mask model is set
+	mask *maskClassifier
+	// expr labels faces' expressions, when an expression model is set
+	expr *expressionClassifier
+}
+
+func loadDetector(cfg detectorConfig) (*detector, error) {
+	faces, eye, err := loadCascades(cfg.CascadesDir, cfg.Classifiers, cfg.CascadesConfig, cfg.faceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &detector{faces: faces, eye: eye}
+
+	if cfg.MaskModel != "" {
+		mc := maskCfg
+		mc.model = cfg.MaskModel
+
+		d.mask, err = newMaskClassifier(mc)
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+	}
+
+	if cfg.ExpressionModel != "" {
+		ec := exprCfg
+		ec.model = cfg.ExpressionModel
+
+		d.expr, err = newExpressionClassifier(ec)
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (d *detector) close() {
+	for _, c := range d.faces {
+		c.classifier.Close()
+	}
+
+	if d.eye != nil {
+		d.eye.classifier.Close()
+	}
+
+	if d.mask != nil {
+		d.mask.close()
+	}
+
+	if d.expr != nil {
+		d.expr.close()
+	}
+}
+
+// setDetector loads a detector with the given configuration, and swaps it in
+// for the current one. Loading happens without holding webcamMu, so capture
+// carries on with the current detector in the meantime. On error, the current
+// detector is kept.
+func setDetector(cfg detectorConfig) error {
+	d, err := loadDetector(cfg)
+	if err != nil {
+		return err
+	}
+
+	webcamMu.Lock()
+	old := det
+	det, detectorCfg = d, cfg
+	// the cached detections came from the old detector
+	scene.reset()
+	webcamMu.Unlock()
+
+	old.close()
+
+	slog.Info("Detector configured", "classifiers", cfg.Classifiers, "cascadesDir", cfg.CascadesDir,
+		"cascadesConfig", cfg.CascadesConfig, "maskModel", cfg.MaskModel, "expressionModel", cfg.ExpressionModel)
+
+	return nil
+}
+
+func handleGetDetector(w http.ResponseWriter, _ *http.Request) {
+	webcamMu.Lock()
+	cfg := detectorCfg
+	webcamMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(cfg)
+}
+
+// handlePutDetector switches detection backends, or reloads the models when
+// the configuration is unchanged.
+func handlePutDetector(w http.ResponseWriter, r *http.Request) {
+	cfg := detectorConfig{}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		http.Error(w, fmt.Sprintf("invalid detector config: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	webcamMu.Lock()
+	cfg.faceSize = detectorCfg.faceSize
+	webcamMu.Unlock()
+
+	if err := setDetector(cfg); err != nil {
+		slog.Warn("reconfiguring detector", "err", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	handleGetDetector(w, r)
+}
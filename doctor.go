@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// doctorCheck is the outcome of one of doctor's checks.
+type doctorCheck struct {
+	name   string
+	ok     bool
+	detail string
+	// fix is a remediation step, for failed checks
+	fix string
+}
+
+// runDoctor implements the doctor subcommand, which checks that the camera
+// and classifiers work, and suggests fixes when they don't - most first-run
+// failures are otherwise opaque OpenCV errors.
+func runDoctor(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cfg := detectorConfig{Classifiers: defaultClassifiers(defaultCascadesDir()), faceSize: defaultFaceSize}
+	cfg.registerFlags(fs)
+	fs.StringVar(&device, "device", device, "video capture device ID, or a video stream URL like rtsp://camera/stream")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	checks := []doctorCheck{
+		{name: "OpenCV", ok: true, detail: fmt.Sprintf("OpenCV %s, GoCV %s", gocv.OpenCVVersion(), gocv.Version())},
+		checkDevicePermissions(),
+	}
+
+	d, check := checkClassifiers(cfg)
+	checks = append(checks, check)
+
+	if d != nil {
+		det = d
+		defer d.close()
+	}
+
+	checks = append(checks, checkCapture(d != nil))
+
+	failed := 0
+	for _, c := range checks {
+		mark := "ok  "
+		if !c.ok {
+			mark = "FAIL"
+			failed++
+		}
+
+		fmt.Printf("[%s] %s: %s\n", mark, c.name, c.detail)
+		if !c.ok && c.fix != "" {
+			fmt.Printf("       fix: %s\n", strings.ReplaceAll(c.fix, "\n", "\n            "))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+
+	fmt.Println("\nEverything looks good!")
+
+	return nil
+}
+
+// checkDevicePermissions checks that a local camera exists and is
+// accessible. Stream URLs are checked by the test capture.
+func checkDevicePermissions() doctorCheck {
+	c := doctorCheck{name: "Camera permissions", ok: true}
+
+	id, err := strconv.Atoi(device)
+	if err != nil {
+		c.detail = "not a local camera, skipped"
+		return c
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		path := "/dev/video" + strconv.Itoa(id)
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			c.ok = false
+			c.detail = path + " doesn't exist"
+			c.fix = "check the camera is connected (see `ls /dev/video*`), and pass its number with -device"
+		case errors.Is(err, fs.ErrPermission):
+			c.ok = false
+			c.detail = "no permission to open " + path
+			c.fix = "add your user to the video group with `sudo usermod -aG video $USER`, then log in again"
+		case err != nil:
+			c.ok = false
+			c.detail = err.Error()
+		default:
+			f.Close()
+			c.detail = path + " is accessible"
+		}
+	case "darwin":
+		// TCC can't be queried without Full Disk Access, so the test capture
+		// tells whether access was granted
+		c.detail = "camera access is granted by macOS on first use - see the test capture"
+	default:
+		c.detail = "not checked on " + runtime.GOOS
+	}
+
+	return c
+}
+
+// checkClassifiers loads the detector, returning it when successful.
+func checkClassifiers(cfg detectorConfig) (*detector, doctorCheck) {
+	c := doctorCheck{name: "Classifiers"}
+
+	d, err := loadDetector(cfg)
+	if err != nil {
+		c.detail = err.Error()
+		c.fix = "pass an OpenCV data directory (containing haarcascades/) with -cascades, or -cascades= -classifiers=haar for the built-in classifier"
+		return nil, c
+	}
+
+	names := []string{}
+	for _, fc := range d.faces {
+		names = append(names, fc.Name)
+	}
+	if d.eye != nil {
+		names = append(names, d.eye.Name)
+	}
+
+	c.ok = true
+	c.detail = "loaded " + strings.Join(names, ", ")
+
+	return d, c
+}
+
+// checkCapture captures a frame, and runs detection on it when the
+// classifiers loaded.
+func checkCapture(detect bool) doctorCheck {
+	c := doctorCheck{name: "Test capture"}
+
+	fix := "check -device is correct, and that no other app is using the camera"
+	if runtime.GOOS == "darwin" {
+		fix = "allow camera access for your terminal app (or the app running presence) in System Settings > Privacy & Security > Camera.\n" +
+			"If it was denied before, reset it with `tccutil reset Camera`, and allow access when prompted on the next run.\n" +
+			"Then " + fix
+	}
+
+	vc, err := gocv.OpenVideoCapture(device)
+	if err != nil {
+		c.detail = fmt.Sprintf("opening %s: %v", redactURL(device), err)
+		c.fix = fix
+		return c
+	}
+	defer vc.Close()
+
+	if !vc.IsOpened() {
+		c.detail = "couldn't open " + redactURL(device)
+		c.fix = fix
+		return c
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	// cameras can take a few frames to start up
+	for i := 0; i < 10; i++ {
+		if vc.Read(&img) && !img.Empty() {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if img.Empty() {
+		c.detail = "no frames read from " + redactURL(device)
+		c.fix = fix
+		return c
+	}
+
+	c.ok = true
+	c.detail = fmt.Sprintf("captured a %dx%d frame", img.Cols(), img.Rows())
+
+	if detect {
+		start := time.Now()
+		faces := detectFaces(&img)
+		c.detail += fmt.Sprintf(", and found %d faces in %v", len(faces), time.Since(start).Round(time.Millisecond))
+	}
+
+	return c
+}
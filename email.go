@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// emailConfig holds the settings for email alerts.
+type emailConfig struct {
+	addr     string
+	username string
+	password string
+	security string
+	from     string
+	to       string
+	events   string
+	// minInterval is the minimum time between alerts, so that a flapping
+	// detector doesn't flood the inbox
+	minInterval time.Duration
+	throttle    throttleConfig
+}
+
+func (c *emailConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.addr, "smtp-addr", "", "SMTP server address (host:port) - enables email alerts")
+	fs.StringVar(&c.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&c.password, "smtp-password", "", "SMTP password (default $PRESENCE_SMTP_PASSWORD)")
+	fs.StringVar(&c.security, "smtp-tls", "starttls", "SMTP transport security: tls, starttls, or none")
+	fs.StringVar(&c.from, "email-from", "", "sender address for email alerts")
+	fs.StringVar(&c.to, "email-to", "", "comma-separated recipient addresses for email alerts")
+	fs.StringVar(&c.events, "email-events", "arrival,departure", "comma-separated events to send email alerts for")
+	fs.DurationVar(&c.minInterval, "email-interval", 5*time.Minute, "minimum time between email alerts")
+	c.throttle.registerSettleFlag(fs, "email", "email alerts")
+}
+
+func (c emailConfig) enabled() bool {
+	return c.addr != ""
+}
+
+type emailNotifier struct {
+	cfg    emailConfig
+	host   string
+	from   string
+	to     []string
+	events map[eventType]bool
+
+	mu       sync.Mutex
+	lastSent time.Time
+}
+
+func newEmailNotifier(cfg emailConfig) (*emailNotifier, error) {
+	host, _, err := net.SplitHostPort(cfg.addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", cfg.addr, err)
+	}
+
+	switch cfg.security {
+	case "tls", "starttls", "none":
+	default:
+		return nil, fmt.Errorf("invalid SMTP security mode %q", cfg.security)
+	}
+
+	if cfg.password == "" {
+		cfg.password = os.Getenv("PRESENCE_SMTP_PASSWORD")
+	}
+
+	from, err := mail.ParseAddress(cfg.from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", cfg.from, err)
+	}
+
+	to, err := mail.ParseAddressList(cfg.to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient addresses %q: %w", cfg.to, err)
+	}
+
+	n := &emailNotifier{
+		cfg:  cfg,
+		host: host,
+		from: from.Address,
+	}
+
+	for _, addr := range to {
+		n.to = append(n.to, addr.Address)
+	}
+
+	n.events, err = parseEventTypes(cfg.events)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (n *emailNotifier) notify(ctx context.Context, ev event) error {
+	if !n.events[ev.Type] {
+		return nil
+	}
+
+	n.mu.Lock()
+	if !n.lastSent.IsZero() && ev.Time.Sub(n.lastSent) < n.cfg.minInterval {
+		n.mu.Unlock()
+		slog.Info("Skipping email alert, too soon after the last one", "event", ev.Type, "last", n.lastSent)
+
+		return nil
+	}
+	n.lastSent = ev.Time
+	n.mu.Unlock()
+
+	msg, err := n.compose(ev)
+	if err != nil {
+		return fmt.Errorf("composing email: %w", err)
+	}
+
+	if err := n.send(ctx, msg); err != nil {
+		return fmt.Errorf("sending email via %s: %w", n.cfg.addr, err)
+	}
+
+	slog.Info("Sent email alert", "event", ev.Type, "to", n.to)
+
+	return nil
+}
+
+// compose builds a MIME message describing the event, with the snapshot
+// attached.
+func (n *emailNotifier) compose(ev event) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+
+	subject := fmt.Sprintf("Presence %s detected", ev.Type)
+
+	fmt.Fprintf(buf, "From: %s\r\n", n.cfg.from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", ev.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mw.Boundary())
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(text, "Presence %s detected at %s (%d face(s) in frame).\r\n",
+		ev.Type, ev.Time.Format(time.RFC1123), ev.Faces)
+
+	if len(ev.Snapshot) > 0 {
+		filename := fmt.Sprintf("%s-%s.jpg", ev.Type, ev.Time.Format("20060102-150405"))
+
+		att, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", filename)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// base64 bodies must be wrapped at 76 characters per line
+		encoded := base64.StdEncoding.EncodeToString(ev.Snapshot)
+		for len(encoded) > 76 {
+			fmt.Fprintf(att, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(att, "%s\r\n", encoded)
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (n *emailNotifier) send(ctx context.Context, msg []byte) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", n.cfg.addr)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tlsConfig := &tls.Config{ServerName: n.host, MinVersion: tls.VersionTLS12}
+
+	if n.cfg.security == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if n.cfg.security == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	if n.cfg.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.cfg.username, n.cfg.password, n.host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+
+	for _, to := range n.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables flags can be set with
+const envPrefix = "PRESENCE_"
+
+// flagEnv returns the environment variable for a flag, like
+// PRESENCE_SMTP_ADDR for -smtp-addr.
+func flagEnv(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets flags from their environment variables, so that the daemon
+// can be configured entirely through the environment (handy in containers).
+// It must be called before parsing the command line, which takes precedence.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(flagEnv(f.Name))
+		if !ok || err != nil {
+			return
+		}
+
+		if serr := fs.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("invalid $%s: %w", flagEnv(f.Name), serr)
+		}
+	})
+
+	return err
+}
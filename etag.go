@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusRevisions numbers each distinct status, for ETags
+var statusRevisions = &revisionTracker{
+	// the revision restarts with the process, so ETags from a previous run
+	// mustn't match
+	boot: strconv.FormatInt(time.Now().UnixNano(), 36),
+}
+
+// revisionTracker assigns a monotonically increasing revision to the status,
+// which changes whenever anything in the status changes. Polling clients can
+// send the revision back in If-None-Match, and get a cheap 304 when nothing
+// has changed.
+type revisionTracker struct {
+	boot string
+
+	mu   sync.Mutex
+	rev  uint64
+	last statusResponse
+}
+
+// etag returns the ETag for the status, with an optional suffix to
+// distinguish different representations.
+func (r *revisionTracker) etag(st statusResponse, suffix string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rev == 0 || st != r.last {
+		r.rev++
+		r.last = st
+	}
+
+	return `"` + r.boot + "-" + strconv.FormatUint(r.rev, 10) + suffix + `"`
+}
+
+// frameETag returns the ETag for a frame of the given scene generation, with
+// an optional suffix to distinguish different representations. Generations
+// only change when the scene does (or at least every -static-max-age).
+func frameETag(gen uint64, suffix string) string {
+	return `"` + statusRevisions.boot + "-f" + strconv.FormatUint(gen, 10) + suffix + `"`
+}
+
+// notModified sets the ETag header, and responds with 304 Not Modified if the
+// request's If-None-Match matches it. It returns true when it responded.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// etagMatch reports whether an If-None-Match header matches the ETag, using
+// weak comparison.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// exportFormats are the dataset formats frames can be exported in
+var exportFormats = []string{"coco", "voc"}
+
+// cocoFlushInterval is how often COCO annotations are written out while
+// frames are being exported, rather than with every frame
+const cocoFlushInterval = time.Minute
+
+// exporter saves analyzed frames and their detections as a dataset, when
+// -export is set. It's nil otherwise.
+var exporter *datasetExporter
+
+// exportConfig holds the dataset export settings.
+type exportConfig struct {
+	dir       string
+	format    string
+	interval  time.Duration
+	empty     bool
+	maxFrames int
+}
+
+func (c *exportConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.dir, "export", "", "directory to export analyzed frames and their detections to, as a dataset for training or evaluating models")
+	fs.StringVar(&c.format, "export-format", "coco", "dataset format for -export: coco or voc (Pascal VOC)")
+	fs.DurationVar(&c.interval, "export-interval", 10*time.Second, "minimum time between exported frames (0 to export every changed frame)")
+	fs.BoolVar(&c.empty, "export-empty", false, "also export frames without detections, as negative examples")
+	fs.IntVar(&c.maxFrames, "export-max-frames", 10000, "most frames to keep in the -export dataset, including those already there, after which exporting stops (0 for no limit)")
+}
+
+func (c exportConfig) enabled() bool {
+	return c.dir != ""
+}
+
+func (c exportConfig) validate() error {
+	if !slices.Contains(exportFormats, c.format) {
+		return fmt.Errorf("invalid -export-format %q: must be coco or voc", c.format)
+	}
+
+	if c.interval < 0 {
+		return fmt.Errorf("invalid -export-interval %v: must not be negative", c.interval)
+	}
+
+	if c.maxFrames < 0 {
+		return fmt.Errorf("invalid -export-max-frames %d: must not be negative", c.maxFrames)
+	}
+
+	return nil
+}
+
+// datasetExporter writes frames and their detections to a directory, in the
+// COCO layout:
+//
+//	images/20240501-090211.000.jpg
+//	annotations.json
+//
+// or the Pascal VOC layout:
+//
+//	JPEGImages/20240501-090211.000.jpg
+//	Annotations/20240501-090211.000.xml
+//
+// COCO annotations are kept in memory, and written out at most every
+// cocoFlushInterval, and when closed.
+type datasetExporter struct {
+	cfg  exportConfig
+	last time.Time
+	// frames is how many frames are in the dataset
+	frames int
+	coco   *cocoDataset
+	// dirty is set when the COCO annotations have changed since they were
+	// flushed
+	dirty   bool
+	flushed time.Time
+}
+
+func newDatasetExporter(cfg exportConfig) (*datasetExporter, error) {
+	e := &datasetExporter{cfg: cfg}
+
+	dirs := []string{filepath.Join(cfg.dir, "JPEGImages"), filepath.Join(cfg.dir, "Annotations")}
+	if cfg.format == "coco" {
+		dirs = []string{filepath.Join(cfg.dir, "images")}
+	}
+
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.format == "coco" {
+		ds, err := loadCOCODataset(e.cocoFile())
+		if err != nil {
+			return nil, err
+		}
+		e.coco = ds
+		e.frames = len(ds.Images)
+	} else {
+		entries, err := os.ReadDir(filepath.Join(cfg.dir, "Annotations"))
+		if err != nil {
+			return nil, err
+		}
+		e.frames = len(entries)
+	}
+
+	if cfg.maxFrames > 0 && e.frames >= cfg.maxFrames {
+		slog.Warn("Export dataset is already full, no frames will be exported", "dir", cfg.dir, "frames", e.frames, "max", cfg.maxFrames)
+	}
+
+	return e, nil
+}
+
+// export saves the unannotated frame and its detections, unless a frame was
+// exported less than -export-interval ago, or the dataset is full. Callers
+// must hold webcamMu.
+func (e *datasetExporter) export(frame gocv.Mat, faces []faceDetection, now time.Time) {
+	if e == nil || (len(faces) == 0 && !e.cfg.empty) || now.Sub(e.last) < e.cfg.interval {
+		return
+	}
+
+	if e.cfg.maxFrames > 0 && e.frames >= e.cfg.maxFrames {
+		return
+	}
+	e.last = now
+
+	img, err := encodeJPEG(frame)
+	if err != nil {
+		slog.Error("encoding exported frame", "err", err)
+		return
+	}
+
+	name := now.Format("20060102-150405.000")
+	width, height := frame.Cols(), frame.Rows()
+
+	if e.cfg.format == "coco" {
+		err = e.exportCOCO(name, img, width, height, faces, now)
+	} else {
+		err = e.exportVOC(name, img, width, height, faces)
+	}
+
+	if err != nil {
+		slog.Error("exporting frame", "dir", e.cfg.dir, "format", e.cfg.format, "err", err)
+		return
+	}
+
+	e.frames++
+	if e.cfg.maxFrames > 0 && e.frames >= e.cfg.maxFrames {
+		slog.Warn("Export dataset is full, no more frames will be exported", "dir", e.cfg.dir, "frames", e.frames)
+	}
+}
+
+// close writes out any COCO annotations not yet flushed. Callers must hold
+// webcamMu.
+func (e *datasetExporter) close() error {
+	if e == nil || !e.dirty {
+		return nil
+	}
+
+	return e.flush(time.Now())
+}
+
+// cocoDataset is a COCO object detection dataset, with a single "face"
+// category.
+type cocoDataset struct {
+	Info        cocoInfo         `json:"info"`
+	Images      []cocoImage      `json:"images"`
+	Annotations []cocoAnnotation `json:"annotations"`
+	Categories  []cocoCategory   `json:"categories"`
+}
+
+type cocoInfo struct {
+	Description string    `json:"description"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+type cocoImage struct {
+	ID           int       `json:"id"`
+	FileName     string    `json:"file_name"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	DateCaptured time.Time `json:"date_captured"`
+}
+
+type cocoAnnotation struct {
+	ID         int `json:"id"`
+	ImageID    int `json:"image_id"`
+	CategoryID int `json:"category_id"`
+	// BBox is [x, y, width, height]
+	BBox    [4]int `json:"bbox"`
+	Area    int    `json:"area"`
+	IsCrowd int    `json:"iscrowd"`
+	// Attributes describe the detection beyond its box, as in CVAT's COCO
+	// exports
+	Attributes detectionAttributes `json:"attributes"`
+}
+
+type detectionAttributes struct {
+	Classifier string `json:"classifier,omitempty"`
+	Masked     *bool  `json:"masked,omitempty"`
+	Expression string `json:"expression,omitempty"`
+}
+
+type cocoCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+const cocoFaceCategory = 1
+
+func (e *datasetExporter) cocoFile() string {
+	return filepath.Join(e.cfg.dir, "annotations.json")
+}
+
+// loadCOCODataset reads an existing dataset to add to, or starts a new one.
+func loadCOCODataset(path string) (*cocoDataset, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &cocoDataset{
+			Info:        cocoInfo{Description: "presence detections", DateCreated: time.Now()},
+			Images:      []cocoImage{},
+			Annotations: []cocoAnnotation{},
+			Categories:  []cocoCategory{{ID: cocoFaceCategory, Name: "face"}},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &cocoDataset{}
+	if err := json.Unmarshal(b, ds); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return ds, nil
+}
+
+// exportCOCO saves the frame, and adds it to the annotations - which are
+// rewritten when they were last flushed at least cocoFlushInterval ago.
+func (e *datasetExporter) exportCOCO(name string, img []byte, width, height int, faces []faceDetection, now time.Time) error {
+	file := name + ".jpg"
+	if err := os.WriteFile(filepath.Join(e.cfg.dir, "images", file), img, 0o640); err != nil {
+		return err
+	}
+
+	ds := e.coco
+
+	imageID := 1
+	if n := len(ds.Images); n > 0 {
+		imageID = ds.Images[n-1].ID + 1
+	}
+	ds.Images = append(ds.Images, cocoImage{ID: imageID, FileName: file, Width: width, Height: height, DateCaptured: now})
+
+	for _, f := range faces {
+		id := 1
+		if n := len(ds.Annotations); n > 0 {
+			id = ds.Annotations[n-1].ID + 1
+		}
+
+		ds.Annotations = append(ds.Annotations, cocoAnnotation{
+			ID:         id,
+			ImageID:    imageID,
+			CategoryID: cocoFaceCategory,
+			BBox:       [4]int{f.X, f.Y, f.Width, f.Height},
+			Area:       f.Width * f.Height,
+			Attributes: detectionAttributes{Classifier: f.Classifier, Masked: f.Masked, Expression: f.Expression},
+		})
+	}
+
+	e.dirty = true
+	if now.Sub(e.flushed) < cocoFlushInterval {
+		return nil
+	}
+
+	return e.flush(now)
+}
+
+// flush rewrites the COCO annotations.
+func (e *datasetExporter) flush(now time.Time) error {
+	b, err := json.Marshal(e.coco)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(e.cocoFile(), b); err != nil {
+		return err
+	}
+
+	e.dirty, e.flushed = false, now
+
+	return nil
+}
+
+// vocAnnotation is a Pascal VOC annotation file.
+type vocAnnotation struct {
+	XMLName   xml.Name    `xml:"annotation"`
+	Folder    string      `xml:"folder"`
+	Filename  string      `xml:"filename"`
+	Source    vocSource   `xml:"source"`
+	Size      vocSize     `xml:"size"`
+	Segmented int         `xml:"segmented"`
+	Objects   []vocObject `xml:"object"`
+}
+
+type vocSource struct {
+	Database string `xml:"database"`
+}
+
+type vocSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+	Depth  int `xml:"depth"`
+}
+
+type vocObject struct {
+	Name      string `xml:"name"`
+	Pose      string `xml:"pose"`
+	Truncated int    `xml:"truncated"`
+	Difficult int    `xml:"difficult"`
+	BndBox    vocBox `xml:"bndbox"`
+}
+
+// vocBox is a bounding box in 1-based pixel coordinates, inclusive.
+type vocBox struct {
+	XMin int `xml:"xmin"`
+	YMin int `xml:"ymin"`
+	XMax int `xml:"xmax"`
+	YMax int `xml:"ymax"`
+}
+
+// exportVOC saves the frame, and an annotation file for it.
+func (e *datasetExporter) exportVOC(name string, img []byte, width, height int, faces []faceDetection) error {
+	file := name + ".jpg"
+	if err := os.WriteFile(filepath.Join(e.cfg.dir, "JPEGImages", file), img, 0o640); err != nil {
+		return err
+	}
+
+	a := vocAnnotation{
+		Folder:   "JPEGImages",
+		Filename: file,
+		Source:   vocSource{Database: "presence"},
+		Size:     vocSize{Width: width, Height: height, Depth: 3},
+		Objects:  []vocObject{},
+	}
+
+	for _, f := range faces {
+		obj := vocObject{
+			Name:   "face",
+			Pose:   "Unspecified",
+			BndBox: vocBox{XMin: f.X + 1, YMin: f.Y + 1, XMax: f.X + f.Width, YMax: f.Y + f.Height},
+		}
+
+		// faces cut off by the edge of the frame
+		if f.X <= 0 || f.Y <= 0 || f.X+f.Width >= width || f.Y+f.Height >= height {
+			obj.Truncated = 1
+		}
+
+		a.Objects = append(a.Objects, obj)
+	}
+
+	b, err := xml.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(e.cfg.dir, "Annotations", name+".xml"), append(b, '\n'))
+}
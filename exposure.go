@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// exposureInterval is how often frames' exposure is measured
+	exposureInterval = time.Second
+	// exposureAdjustInterval is how often the camera's exposure is adjusted,
+	// giving it time to take effect
+	exposureAdjustInterval = 5 * time.Second
+	// exposureSmoothing is how much of the previous measurements are kept,
+	// so conditions don't flap with each frame
+	exposureSmoothing = 0.8
+	// exposureShadow and exposureHighlight are the levels (0-255) at or
+	// beyond which pixels count as clipped
+	exposureShadow    = 16
+	exposureHighlight = 240
+)
+
+// exposure measures the brightness of analyzed frames, to warn of badly
+// exposed cameras - the usual cause of faces going undetected.
+var exposure = &exposureMonitor{}
+
+// exposureConfig holds the exposure thresholds.
+type exposureConfig struct {
+	dark, bright float64
+	minContrast  float64
+	clipped      float64
+	adjust       bool
+}
+
+func (c *exposureConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&c.dark, "exposure-dark", 50, "mean brightness (0-255) below which frames are considered underexposed")
+	fs.Float64Var(&c.bright, "exposure-bright", 205, "mean brightness (0-255) above which frames are considered overexposed")
+	fs.Float64Var(&c.minContrast, "exposure-min-contrast", 20, "standard deviation of brightness (0-255) below which frames are considered too low in contrast")
+	fs.Float64Var(&c.clipped, "exposure-clipped", 0.2, "fraction of pixels which must be both near black and near white for frames to be considered backlit")
+	fs.BoolVar(&c.adjust, "exposure-adjust", false, "adjust the camera's exposure when frames are badly exposed, if the camera supports it")
+}
+
+func (c exposureConfig) validate() error {
+	if c.dark < 0 || c.bright > 255 || c.dark >= c.bright {
+		return fmt.Errorf("invalid -exposure-dark %v or -exposure-bright %v: must be within 0-255, with dark below bright", c.dark, c.bright)
+	}
+
+	if c.clipped <= 0 || c.clipped > 0.5 {
+		return fmt.Errorf("invalid -exposure-clipped %v: must be more than 0 and at most 0.5", c.clipped)
+	}
+
+	return nil
+}
+
+// exposureConditions are the conditions frames' exposure can be in
+var exposureConditions = []string{"ok", "underexposed", "overexposed", "backlit", "low-contrast"}
+
+// exposureStatus describes how well exposed the camera's frames are.
+type exposureStatus struct {
+	// Condition is "ok", "underexposed", "overexposed", "backlit", or
+	// "low-contrast", or "" before any frame has been measured
+	Condition string
+	// Brightness and Contrast are the mean and standard deviation of the
+	// frames' brightness (0-255)
+	Brightness float64
+	Contrast   float64
+	// Shadows and Highlights are the fractions of pixels which are near
+	// black, and near white
+	Shadows    float64
+	Highlights float64
+}
+
+// exposureMonitor keeps a moving average of frames' exposure.
+type exposureMonitor struct {
+	cfg exposureConfig
+
+	mu         sync.Mutex
+	st         exposureStatus
+	measured   time.Time
+	adjusted   time.Time
+	unsettable bool
+}
+
+// observe measures the frame's exposure, at most once every
+// exposureInterval, and adjusts the camera's exposure when enabled. Callers
+// must hold webcamMu.
+func (m *exposureMonitor) observe(frame gocv.Mat, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.measured) < exposureInterval {
+		return
+	}
+	m.measured = now
+
+	thumb := sceneThumb(frame)
+	defer thumb.Close()
+
+	sample, ok := measureExposure(thumb.ToBytes())
+	if !ok {
+		return
+	}
+
+	if m.st.Condition != "" {
+		k := exposureSmoothing
+		sample.Brightness = k*m.st.Brightness + (1-k)*sample.Brightness
+		sample.Contrast = k*m.st.Contrast + (1-k)*sample.Contrast
+		sample.Shadows = k*m.st.Shadows + (1-k)*sample.Shadows
+		sample.Highlights = k*m.st.Highlights + (1-k)*sample.Highlights
+	}
+	sample.Condition = m.cfg.condition(sample)
+
+	if sample.Condition != m.st.Condition {
+		level := slog.LevelWarn
+		if sample.Condition == "ok" {
+			level = slog.LevelInfo
+		}
+		slog.Log(context.Background(), level, "Camera exposure changed", "exposure", sample.Condition,
+			"brightness", math.Round(sample.Brightness), "contrast", math.Round(sample.Contrast))
+	}
+	m.st = sample
+
+	if m.cfg.adjust {
+		m.adjust(now)
+	}
+}
+
+// measureExposure measures a grayscale image's pixels.
+func measureExposure(pixels []byte) (exposureStatus, bool) {
+	if len(pixels) == 0 {
+		return exposureStatus{}, false
+	}
+
+	sum, sumSq, shadows, highlights := 0.0, 0.0, 0, 0
+	for _, p := range pixels {
+		v := float64(p)
+		sum += v
+		sumSq += v * v
+
+		switch {
+		case p <= exposureShadow:
+			shadows++
+		case p >= exposureHighlight:
+			highlights++
+		}
+	}
+
+	n := float64(len(pixels))
+	mean := sum / n
+
+	return exposureStatus{
+		Brightness: mean,
+		Contrast:   math.Sqrt(max(0, sumSq/n-mean*mean)),
+		Shadows:    float64(shadows) / n,
+		Highlights: float64(highlights) / n,
+	}, true
+}
+
+// condition classifies the exposure. Backlighting - a bright window behind
+// someone - shows as both many near-black and many near-white pixels.
+func (c exposureConfig) condition(st exposureStatus) string {
+	switch {
+	case st.Shadows >= c.clipped && st.Highlights >= c.clipped:
+		return "backlit"
+	case st.Brightness < c.dark:
+		return "underexposed"
+	case st.Brightness > c.bright:
+		return "overexposed"
+	case st.Contrast < c.minContrast:
+		return "low-contrast"
+	default:
+		return "ok"
+	}
+}
+
+// adjustableCamera is a capture device whose properties can be set.
+type adjustableCamera interface {
+	Get(prop gocv.VideoCaptureProperties) float64
+	Set(prop gocv.VideoCaptureProperties, v float64)
+}
+
+// adjust nudges the camera's exposure up when frames are underexposed or
+// backlit (to bring out faces in front of the light), and down when
+// overexposed. Cameras report exposure either on a log2 scale (zero or
+// negative), or in absolute units, so it's stepped by one or by a quarter.
+// Callers must hold m.mu and webcamMu.
+func (m *exposureMonitor) adjust(now time.Time) {
+	if m.unsettable || now.Sub(m.adjusted) < exposureAdjustInterval {
+		return
+	}
+
+	var up bool
+	switch m.st.Condition {
+	case "underexposed", "backlit":
+		up = true
+	case "overexposed":
+		up = false
+	default:
+		return
+	}
+
+	cam, ok := webcam.(adjustableCamera)
+	if !ok {
+		slog.Warn("Camera exposure can't be adjusted with this capture device")
+		m.unsettable = true
+		return
+	}
+
+	cur := cam.Get(gocv.VideoCaptureExposure)
+
+	next := cur
+	switch {
+	case cur <= 0 && up:
+		next = cur + 1
+	case cur <= 0:
+		next = cur - 1
+	case up:
+		next = cur * 1.25
+	default:
+		next = cur / 1.25
+	}
+
+	cam.Set(gocv.VideoCaptureExposure, next)
+	m.adjusted = now
+
+	if after := cam.Get(gocv.VideoCaptureExposure); after == cur {
+		slog.Warn("Camera doesn't support adjusting exposure", "exposure", cur)
+		m.unsettable = true
+		return
+	}
+
+	slog.Info("Adjusted camera exposure", "from", cur, "to", next, "exposure", m.st.Condition)
+}
+
+// status returns the current exposure measurements.
+func (m *exposureMonitor) status() exposureStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.st
+}
+
+// reset forgets the measurements, like when the camera is released.
+func (m *exposureMonitor) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.st, m.measured, m.adjusted, m.unsettable = exposureStatus{}, time.Time{}, time.Time{}, false
+}
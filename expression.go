@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// expressionConfig holds the settings for the optional facial expression
+// classifier.
+type expressionConfig struct {
+	model     string
+	config    string
+	labels    string
+	inputSize int
+	gray      bool
+}
+
+func (c *expressionConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.model, "expression-model", "", "DNN model (ONNX, Caffe, TensorFlow, ...) classifying facial expressions (default disabled)")
+	fs.StringVar(&c.config, "expression-model-config", "", "config file for -expression-model, for frameworks that need one")
+	fs.StringVar(&c.labels, "expression-labels", "neutral,smiling,yawning", "comma-separated labels of the -expression-model outputs, in order")
+	fs.IntVar(&c.inputSize, "expression-input-size", 64, "width and height of the -expression-model input, in pixels")
+	fs.BoolVar(&c.gray, "expression-gray", true, "feed -expression-model grayscale images, rather than RGB")
+}
+
+func (c expressionConfig) enabled() bool {
+	return c.model != ""
+}
+
+// expressionClassifier labels faces with their expression, like "smiling".
+// Callers must hold webcamMu.
+type expressionClassifier struct {
+	*faceClassifier
+	labels []string
+}
+
+func newExpressionClassifier(cfg expressionConfig) (*expressionClassifier, error) {
+	labels := []string{}
+	for _, l := range strings.Split(cfg.labels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("-expression-labels must not be empty")
+	}
+
+	fc, err := newFaceClassifier(cfg.model, cfg.config, cfg.inputSize, cfg.gray)
+	if err != nil {
+		return nil, fmt.Errorf("expression classifier: %w", err)
+	}
+
+	return &expressionClassifier{faceClassifier: fc, labels: labels}, nil
+}
+
+// expression returns the most likely expression of the face in the given
+// region of img, and its probability.
+func (e *expressionClassifier) expression(img gocv.Mat, r image.Rectangle) (string, float64, error) {
+	probs := e.classify(img, r)
+	if len(probs) != len(e.labels) {
+		return "", 0, fmt.Errorf("expression model has %d outputs, but %d labels are configured", len(probs), len(e.labels))
+	}
+
+	best := 0
+	for i, p := range probs {
+		if p > probs[best] {
+			best = i
+		}
+	}
+
+	return e.labels[best], probs[best], nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stringsFlag is a flag.Value which can be given multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// intsFlag is a flag.Value holding a comma-separated list of positive
+// integers.
+type intsFlag []int
+
+func (f *intsFlag) String() string {
+	s := make([]string, len(*f))
+	for i, v := range *f {
+		s[i] = strconv.Itoa(v)
+	}
+
+	return strings.Join(s, ",")
+}
+
+func (f *intsFlag) Set(v string) error {
+	vals := intsFlag{}
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid value %q: must be a positive integer", s)
+		}
+
+		vals = append(vals, n)
+	}
+
+	*f = vals
+
+	return nil
+}
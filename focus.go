@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// focusInterval is how often the Focus mode is re-evaluated between events,
+// so that the delays can elapse
+const focusInterval = 10 * time.Second
+
+var errFocusUnsupported = errors.New("focus modes are only supported on macOS")
+
+// focusConfig holds the settings for toggling a macOS Focus mode with
+// presence, by running Shortcuts.
+type focusConfig struct {
+	onShortcut  string
+	offShortcut string
+	onAfter     time.Duration
+	offAfter    time.Duration
+	attention   time.Duration
+}
+
+func (c *focusConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.onShortcut, "focus-on-shortcut", "", "name of a Shortcuts shortcut which turns a Focus mode on, run when presence is detected (macOS only)")
+	fs.StringVar(&c.offShortcut, "focus-off-shortcut", "", "name of a Shortcuts shortcut which turns the Focus mode off, run after being away for -focus-off-after (macOS only)")
+	fs.DurationVar(&c.onAfter, "focus-on-after", 0, "how long presence must last before the Focus mode is turned on")
+	fs.DurationVar(&c.offAfter, "focus-off-after", 15*time.Minute, "how long after departing the Focus mode is turned off")
+	fs.DurationVar(&c.attention, "focus-attention", 0, "only turn the Focus mode on when there's been keyboard/mouse input within this long (0 to ignore input)")
+}
+
+func (c focusConfig) enabled() bool {
+	return c.onShortcut != "" || c.offShortcut != ""
+}
+
+// focusController runs the configured shortcuts as presence comes and goes.
+type focusController struct {
+	cfg     focusConfig
+	tracker *presenceTracker
+	// idle tracks keyboard/mouse input, for -focus-attention
+	idle *idleMonitor
+
+	mu sync.Mutex
+	// focused is whether the Focus mode was last turned on, once known
+	focused *bool
+}
+
+func newFocusController(cfg focusConfig, tracker *presenceTracker, idle *idleMonitor) (*focusController, error) {
+	if runtime.GOOS != "darwin" {
+		return nil, errFocusUnsupported
+	}
+
+	if _, err := exec.LookPath("shortcuts"); err != nil {
+		return nil, fmt.Errorf("finding the shortcuts command: %w", err)
+	}
+
+	return &focusController{cfg: cfg, tracker: tracker, idle: idle}, nil
+}
+
+// watch re-evaluates the Focus mode periodically, until the context is
+// cancelled.
+func (f *focusController) watch(ctx context.Context) {
+	ticker := time.NewTicker(focusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			f.evaluate(ctx, now)
+		}
+	}
+}
+
+func (f *focusController) notify(ctx context.Context, ev event) error {
+	f.evaluate(ctx, ev.Time)
+
+	return nil
+}
+
+// evaluate turns the Focus mode on or off, when the state has lasted long
+// enough.
+func (f *focusController) evaluate(ctx context.Context, now time.Time) {
+	st := f.tracker.status()
+	d := now.Sub(st.Since)
+
+	var want bool
+	switch st.State() {
+	case "present":
+		if d < f.cfg.onAfter || !f.attentive(now) {
+			return
+		}
+		want = true
+	case "away":
+		if d < f.cfg.offAfter {
+			return
+		}
+	default:
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.focused != nil && *f.focused == want {
+		return
+	}
+	f.focused = &want
+
+	shortcut := f.cfg.offShortcut
+	if want {
+		shortcut = f.cfg.onShortcut
+	}
+
+	if shortcut == "" {
+		return
+	}
+
+	if err := runShortcut(ctx, shortcut); err != nil {
+		slog.Warn("toggling Focus mode", "on", want, "err", err)
+		return
+	}
+
+	slog.Info("Toggled Focus mode", "on", want, "shortcut", shortcut)
+}
+
+// attentive reports whether there's been recent keyboard/mouse input, when
+// -focus-attention is set.
+func (f *focusController) attentive(now time.Time) bool {
+	if f.cfg.attention <= 0 || f.idle == nil {
+		return true
+	}
+
+	last := f.idle.lastActive()
+
+	return !last.IsZero() && now.Sub(last) <= f.cfg.attention
+}
+
+// runShortcut runs the named shortcut with the Shortcuts command line tool.
+func runShortcut(ctx context.Context, name string) error {
+	out, err := exec.CommandContext(ctx, "shortcuts", "run", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running shortcut %q: %w: %s", name, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// signal is a source of presence information, such as the camera, a nearby
+// phone, or microphone activity.
+type signal interface {
+	// lastActive returns when the signal last indicated presence, or the zero
+	// time if it never has.
+	lastActive() time.Time
+}
+
+// fusedSignal is a signal along with its place in the fusion policy.
+type fusedSignal struct {
+	signal
+	name   string
+	weight float64
+	// freshness is how long after the signal was last active that it still
+	// counts towards presence
+	freshness time.Duration
+}
+
+func (s fusedSignal) active(now time.Time) bool {
+	last := s.lastActive()
+
+	return !last.IsZero() && now.Sub(last) <= s.freshness
+}
+
+// fusionEngine combines signals into a single presence decision: presence is
+// seen when the weights of all active signals add up to at least the
+// threshold.
+type fusionEngine struct {
+	mu        sync.Mutex
+	signals   []fusedSignal
+	threshold float64
+}
+
+func newFusionEngine(threshold float64) *fusionEngine {
+	return &fusionEngine{threshold: threshold}
+}
+
+func (f *fusionEngine) addSignal(name string, s signal, weight float64, freshness time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.signals = append(f.signals, fusedSignal{
+		signal:    s,
+		name:      name,
+		weight:    weight,
+		freshness: freshness,
+	})
+}
+
+// present reports whether the active signals meet the threshold.
+func (f *fusionEngine) present(now time.Time) bool {
+	return f.status(now).Present
+}
+
+// signalStatus describes a single signal's current contribution.
+type signalStatus struct {
+	Name       string    `json:"name"`
+	Active     bool      `json:"active"`
+	LastActive time.Time `json:"lastActive"`
+	Weight     float64   `json:"weight"`
+	Freshness  float64   `json:"freshness"`
+}
+
+// fusionStatus describes the state of all signals and the result of fusing
+// them.
+type fusionStatus struct {
+	Signals   []signalStatus `json:"signals"`
+	Score     float64        `json:"score"`
+	Threshold float64        `json:"threshold"`
+	Present   bool           `json:"present"`
+}
+
+func (f *fusionEngine) status(now time.Time) fusionStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st := fusionStatus{Threshold: f.threshold, Signals: []signalStatus{}}
+	for _, s := range f.signals {
+		active := s.active(now)
+		if active {
+			st.Score += s.weight
+		}
+
+		st.Signals = append(st.Signals, signalStatus{
+			Name:       s.name,
+			Active:     active,
+			LastActive: s.lastActive(),
+			Weight:     s.weight,
+			Freshness:  s.freshness.Seconds(),
+		})
+	}
+	st.Present = st.Score >= st.Threshold
+
+	return st
+}
+
+// handleSignals reports the status of each signal, for inspecting why the
+// fusion engine did or didn't decide presence.
+func handleSignals(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tracker.fusion.status(time.Now())); err != nil {
+		slog.Error("writing signals", "err", err)
+	}
+}
+
+// cameraSignal is active whenever the camera sees a face.
+type cameraSignal struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (c *cameraSignal) observe(now time.Time, faces int) {
+	if faces == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = now
+}
+
+func (c *cameraSignal) lastActive() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.last
+}
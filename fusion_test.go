@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedSignal is a signal last active at a fixed time.
+type fixedSignal time.Time
+
+func (s fixedSignal) lastActive() time.Time {
+	return time.Time(s)
+}
+
+func TestFusionStatus(t *testing.T) {
+	now := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) fixedSignal { return fixedSignal(now.Add(-d)) }
+
+	type sig struct {
+		s         fixedSignal
+		weight    float64
+		freshness time.Duration
+	}
+
+	tests := []struct {
+		name      string
+		threshold float64
+		signals   []sig
+		score     float64
+		present   bool
+	}{
+		{"no signals", 1, nil, 0, false},
+		{"never active", 1, []sig{{fixedSignal{}, 1, time.Minute}}, 0, false},
+		{"active", 1, []sig{{ago(0), 1, time.Minute}}, 1, true},
+		{"at the end of freshness", 1, []sig{{ago(time.Minute), 1, time.Minute}}, 1, true},
+		{"stale", 1, []sig{{ago(time.Minute + time.Second), 1, time.Minute}}, 0, false},
+		{"below threshold", 1, []sig{{ago(0), 0.5, time.Minute}, {ago(time.Hour), 0.5, time.Minute}}, 0.5, false},
+		{"combined", 1, []sig{{ago(0), 0.5, time.Minute}, {ago(10 * time.Second), 0.5, time.Minute}}, 1, true},
+		{"any of several", 0.5, []sig{{fixedSignal{}, 1, time.Minute}, {ago(time.Second), 0.5, time.Minute}}, 0.5, true},
+		{"zero threshold", 0, []sig{{fixedSignal{}, 1, time.Minute}}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFusionEngine(tt.threshold)
+			for i, s := range tt.signals {
+				f.addSignal(string(rune('a'+i)), s.s, s.weight, s.freshness)
+			}
+
+			st := f.status(now)
+			if st.Score != tt.score || st.Present != tt.present {
+				t.Errorf("score %v, present %t - want %v, %t", st.Score, st.Present, tt.score, tt.present)
+			}
+
+			if len(st.Signals) != len(tt.signals) {
+				t.Errorf("got %d signal statuses, want %d", len(st.Signals), len(tt.signals))
+			}
+
+			if got := f.present(now); got != tt.present {
+				t.Errorf("present() = %t, want %t", got, tt.present)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gpioConfig holds the settings for driving a GPIO pin or PWM LED with the
+// presence state, like an "occupied" light outside an office door.
+type gpioConfig struct {
+	chip      string
+	line      int
+	activeLow bool
+	// pwm is the PWM chip and channel, like "pwmchip0:0"
+	pwm         string
+	period      time.Duration
+	presentDuty float64
+	awayDuty    float64
+}
+
+func (c *gpioConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.chip, "gpio-chip", "gpiochip0", "GPIO chip for -gpio-line")
+	fs.IntVar(&c.line, "gpio-line", -1, "GPIO line (pin offset) to drive high while present (Linux only, default disabled)")
+	fs.BoolVar(&c.activeLow, "gpio-active-low", false, "drive -gpio-line low while present, instead of high")
+	fs.StringVar(&c.pwm, "gpio-pwm", "", "PWM chip and channel to dim an LED with, like pwmchip0:0 (Linux only, default disabled)")
+	fs.DurationVar(&c.period, "gpio-pwm-period", time.Millisecond, "PWM period")
+	fs.Float64Var(&c.presentDuty, "gpio-pwm-present", 100, "PWM duty cycle (brightness) while present, in percent")
+	fs.Float64Var(&c.awayDuty, "gpio-pwm-away", 0, "PWM duty cycle (brightness) while away, in percent")
+}
+
+func (c gpioConfig) enabled() bool {
+	return c.line >= 0 || c.pwm != ""
+}
+
+// presenceOutput is a physical output showing the presence state.
+type presenceOutput interface {
+	set(present bool) error
+	close() error
+}
+
+// gpioNotifier drives its outputs from presence events.
+type gpioNotifier struct {
+	outputs []presenceOutput
+}
+
+func newGPIONotifier(cfg gpioConfig) (*gpioNotifier, error) {
+	n := &gpioNotifier{}
+
+	if cfg.line >= 0 {
+		out, err := openGPIOLine(cfg.chip, cfg.line, cfg.activeLow)
+		if err != nil {
+			return nil, fmt.Errorf("opening GPIO line %s:%d: %w", cfg.chip, cfg.line, err)
+		}
+		n.outputs = append(n.outputs, out)
+	}
+
+	if cfg.pwm != "" {
+		chip, channel, ok := strings.Cut(cfg.pwm, ":")
+		ch, err := strconv.Atoi(channel)
+		if !ok || err != nil {
+			n.close()
+			return nil, fmt.Errorf("invalid PWM %q: must be chip:channel, like pwmchip0:0", cfg.pwm)
+		}
+
+		if cfg.presentDuty < 0 || cfg.presentDuty > 100 || cfg.awayDuty < 0 || cfg.awayDuty > 100 {
+			n.close()
+			return nil, fmt.Errorf("PWM duty cycles must be between 0 and 100%%")
+		}
+
+		out, err := openPWM(chip, ch, cfg.period, cfg.presentDuty, cfg.awayDuty)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("opening PWM %s: %w", cfg.pwm, err)
+		}
+		n.outputs = append(n.outputs, out)
+	}
+
+	return n, nil
+}
+
+func (n *gpioNotifier) notify(_ context.Context, ev event) error {
+	return n.set(ev.State == "present")
+}
+
+func (n *gpioNotifier) set(present bool) error {
+	for _, out := range n.outputs {
+		if err := out.set(present); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// close turns the outputs off and releases them.
+func (n *gpioNotifier) close() {
+	for _, out := range n.outputs {
+		_ = out.set(false)
+		_ = out.close()
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+type gpioLine struct {
+	l *gpiocdev.Line
+}
+
+func openGPIOLine(chip string, offset int, activeLow bool) (presenceOutput, error) {
+	opts := []gpiocdev.LineReqOption{gpiocdev.WithConsumer("presence"), gpiocdev.AsOutput(0)}
+	if activeLow {
+		opts = append(opts, gpiocdev.AsActiveLow)
+	}
+
+	l, err := gpiocdev.RequestLine(chip, offset, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gpioLine{l: l}, nil
+}
+
+func (g *gpioLine) set(present bool) error {
+	v := 0
+	if present {
+		v = 1
+	}
+
+	return g.l.SetValue(v)
+}
+
+func (g *gpioLine) close() error {
+	return g.l.Close()
+}
+
+// pwmOutput is a PWM channel, driven through sysfs.
+type pwmOutput struct {
+	dir         string
+	period      time.Duration
+	presentDuty float64
+	awayDuty    float64
+}
+
+func openPWM(chip string, channel int, period time.Duration, presentDuty, awayDuty float64) (presenceOutput, error) {
+	chipDir := filepath.Join("/sys/class/pwm", chip)
+	dir := filepath.Join(chipDir, "pwm"+strconv.Itoa(channel))
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := writeSysfs(filepath.Join(chipDir, "export"), strconv.Itoa(channel)); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &pwmOutput{dir: dir, period: period, presentDuty: presentDuty, awayDuty: awayDuty}
+
+	if err := writeSysfs(filepath.Join(dir, "period"), strconv.FormatInt(period.Nanoseconds(), 10)); err != nil {
+		return nil, err
+	}
+
+	if err := p.set(false); err != nil {
+		return nil, err
+	}
+
+	if err := writeSysfs(filepath.Join(dir, "enable"), "1"); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *pwmOutput) set(present bool) error {
+	duty := p.awayDuty
+	if present {
+		duty = p.presentDuty
+	}
+
+	ns := int64(float64(p.period.Nanoseconds()) * duty / 100)
+
+	return writeSysfs(filepath.Join(p.dir, "duty_cycle"), strconv.FormatInt(ns, 10))
+}
+
+func (p *pwmOutput) close() error {
+	return writeSysfs(filepath.Join(p.dir, "enable"), "0")
+}
+
+func writeSysfs(path, v string) error {
+	return os.WriteFile(path, []byte(v), 0o644)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errGPIOUnsupported = errors.New("GPIO is only supported on Linux")
+
+func openGPIOLine(_ string, _ int, _ bool) (presenceOutput, error) {
+	return nil, errGPIOUnsupported
+}
+
+func openPWM(_ string, _ int, _ time.Duration, _, _ float64) (presenceOutput, error) {
+	return nil, errGPIOUnsupported
+}
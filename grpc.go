@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/hairyhenderson/presence/presencepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements the gRPC API defined in presencepb/presence.proto.
+type grpcServer struct {
+	presencepb.UnimplementedPresenceServer
+
+	hub *eventHub
+}
+
+func newGRPCServer(hub *eventHub) *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpcAuthUnary), grpc.StreamInterceptor(grpcAuthStream))
+	presencepb.RegisterPresenceServer(s, &grpcServer{hub: hub})
+
+	return s
+}
+
+func (s *grpcServer) GetStatus(_ context.Context, _ *presencepb.GetStatusRequest) (*presencepb.Status, error) {
+	st := currentStatus()
+
+	return &presencepb.Status{
+		State:     st.State,
+		Present:   st.Present,
+		Since:     optionalTimestamp(st.Since),
+		LastSeen:  optionalTimestamp(st.LastSeen),
+		Faces:     int32(st.Faces),
+		Camera:    st.Camera,
+		Paused:    st.Paused,
+		Scheduled: st.Scheduled,
+	}, nil
+}
+
+func (s *grpcServer) StreamEvents(req *presencepb.StreamEventsRequest, stream grpc.ServerStreamingServer[presencepb.Event]) error {
+	if req.GetIncludeSnapshot() {
+		if err := authorizeGRPC(stream.Context(), scopeImages); err != nil {
+			return err
+		}
+
+		auditGRPC(stream.Context(), presencepb.Presence_StreamEvents_FullMethodName)
+	}
+
+	events, unsubscribe := s.hub.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			// Event has no zone, so zones' events would pass for the whole
+			// frame's
+			if ev.Zone != "" {
+				continue
+			}
+
+			pev := &presencepb.Event{
+				Type:     presencepb.EventType_EVENT_TYPE_DEPARTURE,
+				State:    ev.State,
+				Time:     timestamppb.New(ev.Time),
+				LastSeen: optionalTimestamp(ev.LastSeen),
+				Faces:    int32(ev.Faces),
+				Camera:   ev.Camera,
+			}
+
+			if ev.Type == eventArrival {
+				pev.Type = presencepb.EventType_EVENT_TYPE_ARRIVAL
+			}
+
+			// snapshots are left out once the frame quota is used up
+			if req.GetIncludeSnapshot() && ev.Snapshot != nil {
+				if ok, _ := frameQuota.take("grpc", time.Now()); ok {
+					pev.Snapshot = ev.Snapshot
+				}
+			}
+
+			if err := stream.Send(pev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) GetSnapshot(ctx context.Context, _ *presencepb.GetSnapshotRequest) (*presencepb.Snapshot, error) {
+	auditGRPC(ctx, presencepb.Presence_GetSnapshot_FullMethodName)
+
+	if ok, retry := imageLimiter.allow(grpcClientAddr(ctx)); !ok {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many requests, retry in %v", retry.Round(time.Millisecond))
+	}
+
+	release, ok := imageLimiter.acquire()
+	if !ok {
+		return nil, status.Error(codes.ResourceExhausted, "too many concurrent image requests")
+	}
+	defer release()
+
+	taken := time.Now()
+	if ok, _ := frameQuota.take("grpc", taken); !ok {
+		return nil, status.Error(codes.ResourceExhausted, errFrameQuota.Error())
+	}
+
+	img, err := captureJPEG(0)
+	if err != nil {
+		frameQuota.refund("grpc", taken)
+	}
+
+	switch {
+	case captureSkipped(err):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "capturing snapshot: %v", err)
+	}
+
+	return &presencepb.Snapshot{Jpeg: img, Time: timestamppb.Now()}, nil
+}
+
+// auditGRPC records access to images through a gRPC method in the audit log,
+// if enabled.
+func auditGRPC(ctx context.Context, method string) {
+	if audit == nil {
+		return
+	}
+
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+
+	actor := "addr:" + remote
+	if k := apiKeys.lookup(grpcToken(ctx)); k != nil {
+		actor = "key:" + k.Name
+	}
+
+	audit.record(auditEntry{
+		Time:   time.Now(),
+		Actor:  actor,
+		Remote: remote,
+		Action: "access",
+		Method: "gRPC",
+		Path:   method,
+	})
+}
+
+// grpcClientAddr identifies the client making a gRPC call, by IP address -
+// like clientAddr, so a client's HTTP and gRPC image requests share a limit.
+func grpcClientAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// optionalTimestamp converts t to a protobuf timestamp, leaving zero times
+// unset.
+func optionalTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
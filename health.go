@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthTimeout is how long the detection loop can go without a healthy
+// capture before the daemon is considered unhealthy
+var healthTimeout = 30 * time.Second
+
+// health tracks liveness and readiness, for /healthz and /readyz.
+var health = &healthState{}
+
+type healthState struct {
+	mu    sync.Mutex
+	ready bool
+	// lastLoop is when the detection loop last captured successfully (or was
+	// paused, or outside its schedule)
+	lastLoop time.Time
+}
+
+// loopOK records a healthy iteration of the detection loop.
+func (h *healthState) loopOK() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastLoop = time.Now()
+}
+
+// setReady marks the daemon ready to serve, or not (while shutting down).
+func (h *healthState) setReady(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ready = ready
+	if ready && h.lastLoop.IsZero() {
+		// give the loop until the timeout to report in
+		h.lastLoop = time.Now()
+	}
+}
+
+// live reports whether the detection loop is working, with a reason when
+// it's not.
+func (h *healthState) live(now time.Time) (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if detectInterval <= 0 || h.lastLoop.IsZero() {
+		return true, ""
+	}
+
+	if since := now.Sub(h.lastLoop); since > healthTimeout {
+		return false, "no healthy capture for " + since.Round(time.Second).String()
+	}
+
+	return true, ""
+}
+
+func (h *healthState) isReady() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.ready
+}
+
+// handleHealthz is a liveness check, failing when the detection loop is
+// stuck or the camera is failing.
+func handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	if ok, reason := health.live(time.Now()); !ok {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// handleReadyz is a readiness check, failing until the camera and
+// classifiers are loaded, while shutting down, and when unhealthy.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !health.isReady() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	handleHealthz(w, r)
+}
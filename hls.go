@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// hlsIdleTimeout is how long the segmenter keeps running after the last
+	// request, before ffmpeg is stopped
+	hlsIdleTimeout = 30 * time.Second
+	// hlsPlaylist is the name of the HLS playlist
+	hlsPlaylist = "index.m3u8"
+)
+
+// hlsSegmentFile matches the segments ffmpeg writes, which are named for the
+// width of the stream they belong to, so nothing else can be served from the
+// segment directories
+var hlsSegmentFile = regexp.MustCompile(`^seg([0-9]+)-[0-9]+\.ts$`)
+
+// hlsConfig holds the settings for the HLS stream.
+type hlsConfig struct {
+	enable  bool
+	ffmpeg  string
+	segment time.Duration
+	list    int
+}
+
+func (c *hlsConfig) registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.enable, "hls", false, "serve an HLS stream at /hls/index.m3u8 (requires ffmpeg)")
+	fs.StringVar(&c.ffmpeg, "ffmpeg", "ffmpeg", "path to the ffmpeg binary")
+	fs.DurationVar(&c.segment, "hls-segment", 2*time.Second, "duration of each HLS segment")
+	fs.IntVar(&c.list, "hls-list-size", 5, "number of segments in the HLS playlist")
+}
+
+func (c hlsConfig) enabled() bool {
+	return c.enable
+}
+
+// hlsSegmenter encodes the annotated frames to H.264 and segments them for
+// HLS with ffmpeg. ffmpeg is only run while the stream is being watched, once
+// for each width being watched.
+type hlsSegmenter struct {
+	cfg    hlsConfig
+	fps    float64
+	frames *frameHub
+
+	mu       sync.Mutex
+	sessions map[int]*hlsSession
+}
+
+// hlsSession is a single run of ffmpeg.
+type hlsSession struct {
+	dir   string
+	width int
+	// ready is closed once the playlist has been written
+	ready chan struct{}
+	// lastRequest is guarded by the segmenter's mutex
+	lastRequest time.Time
+}
+
+func newHLSSegmenter(cfg hlsConfig, fps float64, frames *frameHub) *hlsSegmenter {
+	return &hlsSegmenter{cfg: cfg, fps: fps, frames: frames, sessions: map[int]*hlsSession{}}
+}
+
+// handleHLS serves the HLS playlist and segments.
+func handleHLS(w http.ResponseWriter, r *http.Request) {
+	if hlsStream == nil {
+		http.Error(w, "HLS is not enabled", http.StatusNotFound)
+		return
+	}
+
+	hlsStream.serve(w, r)
+}
+
+func (s *hlsSegmenter) serve(w http.ResponseWriter, r *http.Request) {
+	// the playlist is requested with ?width=, and its segments are named
+	// for the width
+	name := r.PathValue("file")
+
+	var width int
+	switch m := hlsSegmentFile.FindStringSubmatch(name); {
+	case name == hlsPlaylist:
+		var err error
+		width, err = requestWidth(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case m != nil:
+		width, _ = strconv.Atoi(m[1])
+		if width != 0 && !slices.Contains(resizeWidths, width) {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	sess, err := s.session(width)
+	if err != nil {
+		slog.Error("starting HLS segmenter", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the first segment takes a while to be written
+	select {
+	case <-sess.ready:
+	case <-time.After(s.cfg.segment*3 + 5*time.Second):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "HLS stream is starting", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	if name == hlsPlaylist {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+
+	http.ServeFile(w, r, filepath.Join(sess.dir, name))
+}
+
+// session returns the running ffmpeg session for the width, starting one if
+// needed.
+func (s *hlsSegmenter) session(width int) (*hlsSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[width]; ok {
+		sess.lastRequest = time.Now()
+		return sess, nil
+	}
+
+	dir, err := os.MkdirTemp("", "presence-hls-")
+	if err != nil {
+		return nil, fmt.Errorf("creating HLS directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, s.cfg.ffmpeg, s.args(dir, width)...)
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("starting ffmpeg: %w", err)
+	}
+
+	slog.Info("HLS segmenter started", "dir", dir, "width", width)
+
+	sess := &hlsSession{dir: dir, width: width, ready: make(chan struct{}), lastRequest: time.Now()}
+	s.sessions[width] = sess
+
+	go func(sess *hlsSession) {
+		s.feed(sess, stdin)
+
+		_ = stdin.Close()
+		cancel()
+		_ = cmd.Wait()
+		if stderr.Len() > 0 {
+			slog.Debug("ffmpeg output", "output", stderr.String())
+		}
+
+		_ = os.RemoveAll(sess.dir)
+
+		s.mu.Lock()
+		if s.sessions[sess.width] == sess {
+			delete(s.sessions, sess.width)
+		}
+		s.mu.Unlock()
+
+		slog.Info("HLS segmenter stopped", "width", sess.width)
+	}(sess)
+
+	return sess, nil
+}
+
+func (s *hlsSegmenter) args(dir string, width int) []string {
+	gop := strconv.Itoa(max(1, int(s.fps*s.cfg.segment.Seconds())))
+
+	return []string{
+		"-nostdin", "-loglevel", "error",
+		"-f", "image2pipe", "-c:v", "mjpeg",
+		"-framerate", strconv.FormatFloat(s.fps, 'f', -1, 64),
+		"-i", "-",
+		// x264 needs even dimensions, and Safari needs yuv420p
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-pix_fmt", "yuv420p",
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency",
+		// a keyframe at each segment boundary
+		"-g", gop, "-keyint_min", gop, "-sc_threshold", "0",
+		"-f", "hls",
+		"-hls_time", strconv.FormatFloat(s.cfg.segment.Seconds(), 'f', -1, 64),
+		"-hls_list_size", strconv.Itoa(s.cfg.list),
+		"-hls_flags", "delete_segments+omit_endlist",
+		"-hls_segment_filename", filepath.Join(dir, "seg"+strconv.Itoa(width)+"-%d.ts"),
+		filepath.Join(dir, hlsPlaylist),
+	}
+}
+
+// feed pipes frames into ffmpeg until nobody has requested the stream for a
+// while, or ffmpeg exits.
+func (s *hlsSegmenter) feed(sess *hlsSession, stdin io.Writer) {
+	frames, unsubscribe := s.frames.subscribe("hls", "", sess.width)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		select {
+		case <-ticker.C:
+			if !ready {
+				if _, err := os.Stat(filepath.Join(sess.dir, hlsPlaylist)); err == nil {
+					close(sess.ready)
+					ready = true
+				}
+			}
+
+			s.mu.Lock()
+			idle := time.Since(sess.lastRequest)
+			s.mu.Unlock()
+
+			if idle > hlsIdleTimeout {
+				return
+			}
+		case img, ok := <-frames:
+			if !ok {
+				return
+			}
+
+			if _, err := stdin.Write(img); err != nil {
+				slog.Warn("writing frame to ffmpeg", "err", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventHub fans events out to any number of subscribers, such as streaming
+// API clients. Slow subscribers miss events rather than holding up the
+// others.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[chan event]struct{}{}}
+}
+
+// subscribe returns a channel which receives events, and a function to
+// unsubscribe.
+func (h *eventHub) subscribe() (<-chan event, func()) {
+	ch := make(chan event, 16)
+
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) notify(_ context.Context, ev event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			slog.Warn("Dropping event for slow subscriber", "event", ev.Type)
+		}
+	}
+
+	return nil
+}
+
+// sseKeepalive is how often a comment is sent to idle event streams, so that
+// proxies don't time them out
+const sseKeepalive = 30 * time.Second
+
+// handleEvents streams events to the client as Server-Sent Events.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(ev)
+			if err != nil {
+				slog.Error("marshalling event", "err", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idlePollInterval is how often the system idle time is read
+const idlePollInterval = 5 * time.Second
+
+// idleConfig holds the settings for the keyboard/mouse idle signal.
+type idleConfig struct {
+	enable  bool
+	timeout time.Duration
+	weight  float64
+}
+
+func (c *idleConfig) registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.enable, "idle", false, "use recent keyboard/mouse activity on this machine as a presence signal")
+	fs.DurationVar(&c.timeout, "idle-timeout", 2*time.Minute, "freshness window: how long after the last keyboard/mouse input it counts as presence")
+	fs.Float64Var(&c.weight, "idle-weight", 1, "weight of keyboard/mouse activity in the fusion policy")
+}
+
+func (c idleConfig) enabled() bool {
+	return c.enable
+}
+
+// idleMonitor polls the OS for the time since the last keyboard or mouse
+// input.
+type idleMonitor struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newIdleMonitor() *idleMonitor {
+	return &idleMonitor{}
+}
+
+// watch polls the idle time until the context is cancelled.
+func (m *idleMonitor) watch(ctx context.Context) {
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		idle, err := systemIdleTime(ctx)
+		switch {
+		case err != nil && !warned:
+			slog.Warn("reading system idle time", "err", err)
+			warned = true
+		case err == nil:
+			m.mu.Lock()
+			m.last = time.Now().Add(-idle)
+			m.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *idleMonitor) lastActive() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.last
+}
+
+var hidIdleTimeRE = regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
+
+// systemIdleTime returns how long it's been since the last keyboard or mouse
+// input. On macOS this is IOKit's HIDIdleTime; on Linux it's read from X11
+// with xprintidle, or from GNOME's idle monitor under Wayland.
+func systemIdleTime(ctx context.Context) (time.Duration, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := exec.CommandContext(ctx, "ioreg", "-c", "IOHIDSystem", "-d", "4", "-r", "-k", "HIDIdleTime").Output()
+		if err != nil {
+			return 0, fmt.Errorf("ioreg: %w", err)
+		}
+
+		m := hidIdleTimeRE.FindSubmatch(out)
+		if m == nil {
+			return 0, errors.New("HIDIdleTime not found in ioreg output")
+		}
+
+		ns, err := strconv.ParseInt(string(m[1]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing HIDIdleTime: %w", err)
+		}
+
+		return time.Duration(ns), nil
+	case "linux":
+		if os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("DISPLAY") == "" {
+			return gnomeIdleTime(ctx)
+		}
+
+		out, err := exec.CommandContext(ctx, "xprintidle").Output()
+		if err != nil {
+			return 0, fmt.Errorf("xprintidle: %w", err)
+		}
+
+		ms, err := strconv.ParseInt(string(bytes.TrimSpace(out)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing xprintidle output: %w", err)
+		}
+
+		return time.Duration(ms) * time.Millisecond, nil
+	default:
+		return 0, fmt.Errorf("reading idle time is not supported on %s", runtime.GOOS)
+	}
+}
+
+// gnomeIdleTime asks GNOME's (Mutter's) idle monitor for the idle time, which
+// works under Wayland where X11 tools can't see input.
+func gnomeIdleTime(ctx context.Context) (time.Duration, error) {
+	out, err := exec.CommandContext(ctx, "gdbus", "call", "--session",
+		"--dest", "org.gnome.Mutter.IdleMonitor",
+		"--object-path", "/org/gnome/Mutter/IdleMonitor/Core",
+		"--method", "org.gnome.Mutter.IdleMonitor.GetIdletime").Output()
+	if err != nil {
+		return 0, fmt.Errorf("querying GNOME idle monitor: %w", err)
+	}
+
+	// output looks like "(uint64 12345,)"
+	s := strings.Trim(string(bytes.TrimSpace(out)), "(),")
+	s = strings.TrimPrefix(s, "uint64 ")
+
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing GNOME idle time %q: %w", out, err)
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxConfig holds the settings for the InfluxDB line protocol exporter.
+type influxConfig struct {
+	url         string
+	token       string
+	measurement string
+	interval    time.Duration
+}
+
+func (c *influxConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.url, "influx-url", "", "line protocol write URL, like http://influx:8086/api/v2/write?org=home&bucket=presence - enables the exporter")
+	fs.StringVar(&c.token, "influx-token", "", "InfluxDB API token (default $PRESENCE_INFLUX_TOKEN)")
+	fs.StringVar(&c.measurement, "influx-measurement", "presence", "measurement name for exported points")
+	fs.DurationVar(&c.interval, "influx-interval", 10*time.Second, "how often to export the presence state")
+}
+
+func (c influxConfig) enabled() bool {
+	return c.url != ""
+}
+
+// influxExporter periodically writes the presence state as InfluxDB line
+// protocol, which most time-series databases accept.
+type influxExporter struct {
+	cfg     influxConfig
+	tracker *presenceTracker
+	hc      *http.Client
+}
+
+func newInfluxExporter(cfg influxConfig, tracker *presenceTracker) (*influxExporter, error) {
+	u, err := url.Parse(cfg.url)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q", cfg.url)
+	}
+
+	if cfg.interval <= 0 {
+		return nil, fmt.Errorf("invalid InfluxDB export interval %v", cfg.interval)
+	}
+
+	if cfg.token == "" {
+		cfg.token = os.Getenv("PRESENCE_INFLUX_TOKEN")
+	}
+
+	return &influxExporter{
+		cfg:     cfg,
+		tracker: tracker,
+		hc:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// watch exports the presence state every interval until the context is
+// cancelled.
+func (e *influxExporter) watch(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := e.export(ctx, time.Now()); err != nil {
+			slog.Warn("exporting to InfluxDB", "err", err)
+		}
+	}
+}
+
+func (e *influxExporter) export(ctx context.Context, now time.Time) error {
+	body := e.line(now)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.cfg.token != "" {
+		req.Header.Set("Authorization", "Token "+e.cfg.token)
+	}
+
+	resp, err := e.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// line renders the current presence state as a line protocol point. The
+// confidence is the share of the total signal weight which is active.
+func (e *influxExporter) line(now time.Time) string {
+	st := e.tracker.status()
+	fs := e.tracker.fusion.status(now)
+
+	total := 0.0
+	for _, s := range fs.Signals {
+		total += s.Weight
+	}
+
+	confidence := 0.0
+	if total > 0 {
+		confidence = fs.Score / total
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(influxEscape(e.cfg.measurement, ", "))
+	b.WriteString(",camera=")
+	b.WriteString(influxEscape(st.Camera, ", ="))
+
+	fmt.Fprintf(b, " state=%q,known=%t,present=%t,faces=%di,score=%s,confidence=%s",
+		st.State(), st.Known, st.Present, st.Faces,
+		strconv.FormatFloat(fs.Score, 'f', -1, 64),
+		strconv.FormatFloat(confidence, 'f', -1, 64))
+
+	for _, s := range fs.Signals {
+		fmt.Fprintf(b, ",%s=%t", influxEscape("signal_"+s.Name, ", ="), s.Active)
+	}
+
+	fmt.Fprintf(b, " %d\n", now.UnixNano())
+
+	return b.String()
+}
+
+// influxEscape backslash-escapes the given characters, as line protocol needs
+// for measurements, tags, and field keys.
+func influxEscape(s, chars string) string {
+	b := &strings.Builder{}
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
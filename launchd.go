@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// launchdLabel identifies the launchd agent
+const launchdLabel = "com.github.hairyhenderson.presence"
+
+const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ProcessType</key>
+	<string>Interactive</string>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+// launchdPaths returns the paths of the agent's plist and log file.
+func launchdPaths() (plist, logFile string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	plist = filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+	logFile = filepath.Join(home, "Library", "Logs", "presence.log")
+
+	return plist, logFile, nil
+}
+
+// runInstallAgent implements the install-agent subcommand, which installs and
+// loads a launchd agent running the daemon at login, with the arguments given
+// after --, like:
+//
+//	presence install-agent -- -device=1 -listen=:8888
+func runInstallAgent(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("%s is only supported on macOS - see systemd-unit for Linux", cmd)
+	}
+
+	plist, logFile, err := launchdPaths()
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding executable: %w", err)
+	}
+
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return fmt.Errorf("finding executable: %w", err)
+	}
+
+	progArgs := ""
+	for _, a := range append([]string{exe}, fs.Args()...) {
+		progArgs += "\t\t<string>" + xmlEscape(a) + "</string>\n"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(plist), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logFile), 0o755); err != nil {
+		return err
+	}
+
+	// reinstalling replaces the running agent
+	if _, err := os.Stat(plist); err == nil {
+		_ = launchctl("bootout", launchdDomain()+"/"+launchdLabel)
+	}
+
+	content := fmt.Sprintf(plistTemplate, launchdLabel, progArgs, xmlEscape(logFile), xmlEscape(logFile))
+	if err := os.WriteFile(plist, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", plist, err)
+	}
+
+	if err := launchctl("bootstrap", launchdDomain(), plist); err != nil {
+		return err
+	}
+
+	slog.Info("Installed launchd agent", "plist", plist, "log", logFile)
+
+	return nil
+}
+
+// runUninstallAgent implements the uninstall-agent subcommand, which unloads
+// and removes the launchd agent.
+func runUninstallAgent(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("%s is only supported on macOS", cmd)
+	}
+
+	plist, _, err := launchdPaths()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(plist); err != nil {
+		return fmt.Errorf("launchd agent not installed: %w", err)
+	}
+
+	if err := launchctl("bootout", launchdDomain()+"/"+launchdLabel); err != nil {
+		slog.Warn("unloading launchd agent", "err", err)
+	}
+
+	if err := os.Remove(plist); err != nil {
+		return err
+	}
+
+	slog.Info("Uninstalled launchd agent", "plist", plist)
+
+	return nil
+}
+
+// launchdDomain is the current user's GUI domain, where login agents run.
+func launchdDomain() string {
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %w: %s", args[0], err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+func xmlEscape(s string) string {
+	b := &bytes.Buffer{}
+	_ = xml.EscapeText(b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logConfig holds the logging settings.
+type logConfig struct {
+	level      string
+	format     string
+	file       string
+	maxSize    int
+	maxBackups int
+}
+
+func (c *logConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.level, "log-level", "info", "minimum log level: debug, info, warn, or error")
+	fs.StringVar(&c.format, "log-format", "text", "log format: text or json")
+	fs.StringVar(&c.file, "log-file", "", "file to log to, rotated when it grows too large (default stderr)")
+	fs.IntVar(&c.maxSize, "log-max-size", 100, "size in megabytes at which -log-file is rotated")
+	fs.IntVar(&c.maxBackups, "log-max-backups", 3, "number of rotated log files to keep")
+}
+
+// setupLogging configures the default slog logger, returning a function which
+// closes the log file, if any.
+func setupLogging(c logConfig) (func(), error) {
+	level := slog.LevelInfo
+	if err := level.UnmarshalText([]byte(c.level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", c.level)
+	}
+
+	var out io.Writer = os.Stderr
+	closer := func() {}
+
+	if c.file != "" {
+		lj := &lumberjack.Logger{
+			Filename:   c.file,
+			MaxSize:    c.maxSize,
+			MaxBackups: c.maxBackups,
+		}
+		out = lj
+		closer = func() { _ = lj.Close() }
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch c.format {
+	case "text":
+		h = slog.NewTextHandler(out, opts)
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	default:
+		closer()
+		return nil, fmt.Errorf("invalid log format %q", c.format)
+	}
+
+	slog.SetDefault(slog.New(h))
+
+	return closer, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// loopbackRestartDelay is how long to wait before restarting ffmpeg when it
+// exits, like when the loopback device is busy
+const loopbackRestartDelay = 5 * time.Second
+
+// loopbackConfig holds the settings for publishing the annotated stream to a
+// v4l2loopback device, as a virtual camera for video-conferencing apps and
+// other tools.
+type loopbackConfig struct {
+	device string
+	width  int
+}
+
+func (c *loopbackConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.device, "v4l2-output", "", "v4l2loopback device to publish the annotated stream to, like /dev/video10 (Linux only, requires ffmpeg)")
+	fs.IntVar(&c.width, "v4l2-output-width", 0, "width to publish to -v4l2-output at, one of -widths (default full size)")
+}
+
+func (c loopbackConfig) enabled() bool {
+	return c.device != ""
+}
+
+func (c loopbackConfig) validate() error {
+	if runtime.GOOS != "linux" {
+		return errors.New("-v4l2-output is only supported on Linux")
+	}
+
+	if c.width != 0 && !slices.Contains(resizeWidths, c.width) {
+		return fmt.Errorf("unsupported -v4l2-output-width %d, must be one of %s", c.width, resizeWidths.String())
+	}
+
+	return nil
+}
+
+// loopbackWriter pipes the annotated frames into ffmpeg, which writes them to
+// the loopback device. Unlike the other streams it runs all the time, as
+// there's no telling when the virtual camera is being watched.
+type loopbackWriter struct {
+	cfg    loopbackConfig
+	ffmpeg string
+	fps    float64
+	frames *frameHub
+}
+
+// run publishes frames until the context is cancelled, restarting ffmpeg
+// whenever it exits.
+func (l *loopbackWriter) run(ctx context.Context) {
+	for {
+		err := l.publish(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("publishing to v4l2 loopback device, restarting", "device", l.cfg.device, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(loopbackRestartDelay):
+		}
+	}
+}
+
+// publish runs ffmpeg until it exits, or the context is cancelled.
+func (l *loopbackWriter) publish(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, l.ffmpeg, l.args()...)
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting ffmpeg: %w", err)
+	}
+
+	slog.Info("Publishing to v4l2 loopback device", "device", l.cfg.device, "width", l.cfg.width)
+
+	err = l.feed(ctx, stdin)
+
+	_ = stdin.Close()
+	cancel()
+	_ = cmd.Wait()
+
+	if stderr.Len() > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return err
+}
+
+func (l *loopbackWriter) args() []string {
+	return []string{
+		"-nostdin", "-loglevel", "error",
+		"-f", "image2pipe", "-c:v", "mjpeg",
+		"-framerate", strconv.FormatFloat(l.fps, 'f', -1, 64),
+		"-i", "-",
+		// most apps only accept even dimensions and yuv420p
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-pix_fmt", "yuv420p",
+		"-f", "v4l2", l.cfg.device,
+	}
+}
+
+// feed pipes frames into ffmpeg until the context is cancelled, or ffmpeg
+// stops reading.
+func (l *loopbackWriter) feed(ctx context.Context, stdin io.Writer) error {
+	frames, unsubscribe := l.frames.subscribe("v4l2", l.cfg.device, l.cfg.width)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case img, ok := <-frames:
+			if !ok {
+				return errors.New("frame stream closed")
+			}
+
+			if _, err := stdin.Write(img); err != nil {
+				return fmt.Errorf("writing frame to ffmpeg: %w", err)
+			}
+		}
+	}
+}
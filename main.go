@@ -1,153 +1,1199 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
-	"image/color"
-	"image/jpeg"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"os/exec"
+	ossignal "os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"gocv.io/x/gocv"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
-	deviceID = 0
-	err      error
-	webcam   *gocv.VideoCapture
+	// device is the capture device ID, or a video file or stream URL (like
+	// rtsp://...)
+	device = "0"
+	err    error
+	webcam frameSource
+	// webcamMu guards the webcam and the classifiers, which are shared between
+	// HTTP requests and the background detection loop
+	webcamMu sync.Mutex
 	// img          gocv.Mat
-	haarFaceCascade gocv.CascadeClassifier
-	eyeCascade      gocv.CascadeClassifier
-	lbpFaceCascade  gocv.CascadeClassifier
+
+	// lastDetected is when the newest frame to reach the detect stage was
+	// read, guarded by webcamMu
+	lastDetected time.Time
 
 	font = gocv.FontHersheyPlain
 
-	// these values make sense on my Apple Studio Display's webcam, but may need
-	// adjustment for other webcams
-	minFaceSize = 200
-	maxFaceSize = 600
+	listenAddr = "127.0.0.1:8888"
+	// grpcAddr is where the gRPC API listens, if set
+	grpcAddr = ""
+
+	// how often to check for presence when nobody is requesting images
+	detectInterval = 1 * time.Second
+	// how long no faces must be seen before presence is considered to have
+	// departed
+	awayTimeout = 30 * time.Second
+
+	tracker     *presenceTracker
+	events      = newEventHub()
+	emailCfg    emailConfig
+	templateCfg templateConfig
+	pluginsDir  string
+	rulesFile   string
+	bleCfg      bleConfig
+	netCfg      networkConfig
+	audioCfg    audioConfig
+	idleCfg     idleConfig
+	influxCfg   influxConfig
+	postgresCfg postgresConfig
+	gpioCfg     gpioConfig
+	maskCfg     maskConfig
+	exprCfg     expressionConfig
+	wolCfg      wolConfig
+	remoteCfg   remoteConfig
+	relayCfg    relayConfig
+	annotateCfg annotationConfig
+	focusCfg    focusConfig
+	zoneCfg     zoneConfig
+	stdinCfg    stdinConfig
+	loopbackCfg loopbackConfig
+
+	// pluginsThrottle throttles the events passed to plugins
+	pluginsThrottle throttleConfig
+
+	authCfg   authConfig
+	auditCfg  auditConfig
+	recordCfg recordConfig
+
+	// tuningFile persists detection parameters tuned at runtime
+	tuningFile string
+
+	exposureCfg exposureConfig
+
+	profilesCfg profilesConfig
+
+	exportCfg exportConfig
+
+	historyCfg historyConfig
+
+	quotaCfg quotaConfig
+
+	// fusion policy - see fusionEngine
+	cameraWeight    = 1.0
+	cameraFreshness = 0 * time.Second
+	fusionThreshold = 1.0
+
+	scheduleSpecs stringsFlag
+	scheduleTZ    string
+	// awayTimeoutSpecs override -away-timeout at times of day
+	awayTimeoutSpecs stringsFlag
+	// activeSchedule limits when the camera is used - outside of it the camera
+	// is released
+	activeSchedule *schedule
+
+	// streamFPS is the frame rate for live streams
+	streamFPS    = 10.0
+	streamFrames *frameHub
+	hlsCfg       hlsConfig
+	hlsStream    *hlsSegmenter
+	// resizeWidths are the widths frames can be requested at, to save
+	// bandwidth for small screens
+	resizeWidths = intsFlag{320, 640, 1280}
+
+	logCfg logConfig
+	// containerMode adjusts defaults for running in a container
+	containerMode bool
+	limitCfg      limitConfig
+	imageLimiter  *requestLimiter
+
+	errOutsideSchedule = errors.New("capture paused outside of the configured schedule")
+	errNoCamera        = errors.New("no local camera, only aggregating remote instances")
 )
 
+// captureSkipped reports whether a capture error means capture is
+// deliberately not happening, rather than failing.
+func captureSkipped(err error) bool {
+	return errors.Is(err, errPaused) || errors.Is(err, errOutsideSchedule) || errors.Is(err, errNoCamera)
+}
+
+// subcommands are run instead of the daemon when named as the first argument
+var subcommands = map[string]func(cmd string, args []string) error{
+	"pause":  runControl,
+	"resume": runControl,
+	"toggle": runControl,
+
+	"systemd-unit":    runSystemdUnit,
+	"install-agent":   runInstallAgent,
+	"uninstall-agent": runUninstallAgent,
+	"bench":           runBench,
+	"doctor":          runDoctor,
+	"relay":           runRelay,
+	"audit-verify":    runAuditVerify,
+	"purge":           runPurge,
+	"replay":          runReplay,
+}
+
 func main() {
-	if err := run(); err != nil {
+	var err error
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		err = subcommands[os.Args[1]](os.Args[1], os.Args[2:])
+	} else {
+		err = run()
+	}
+
+	if err != nil {
 		slog.Error("Exiting with error", "err", err)
 		os.Exit(1)
 	}
 }
 
 func run() error {
-	// Open webcam
-	webcam, err = gocv.OpenVideoCapture(deviceID)
+	ctx, stop := ossignal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logCfg.registerFlags(flag.CommandLine)
+	flag.BoolVar(&containerMode, "container", false, "run in a container: listen on all interfaces and log JSON, unless set otherwise")
+	detectorCfg.registerFlags(flag.CommandLine)
+	flag.DurationVar(&healthTimeout, "health-timeout", healthTimeout, "time without a healthy capture before /healthz fails")
+	flag.StringVar(&device, "device", device, "video capture device ID, a video stream URL like rtsp://camera/stream, or - for frames piped on stdin")
+	stdinCfg.registerFlags(flag.CommandLine)
+	flag.StringVar(&listenAddr, "listen", listenAddr, "address for the HTTP server to listen on")
+	flag.StringVar(&grpcAddr, "grpc-listen", grpcAddr, "address for the gRPC API to listen on (default disabled)")
+	flag.DurationVar(&detectInterval, "interval", detectInterval, "background detection interval (0 to disable)")
+	flag.DurationVar(&awayTimeout, "away-timeout", awayTimeout, "time without a detected face before presence is considered departed")
+	flag.Var(&awayTimeoutSpecs, "away-timeout-at", "away timeout during a window, like \"Mon-Fri 09:00-17:00=2m\", in -timezone; may be repeated, and the first active window applies (default -away-timeout)")
+	emailCfg.registerFlags(flag.CommandLine)
+	templateCfg.registerFlags(flag.CommandLine)
+	flag.StringVar(&pluginsDir, "plugins-dir", "", "directory of executables to run for each event, with the event as JSON on stdin")
+	pluginsThrottle.registerFlags(flag.CommandLine, "plugins", "plugin runs")
+	authCfg.registerFlags(flag.CommandLine)
+	auditCfg.registerFlags(flag.CommandLine)
+	recordCfg.registerFlags(flag.CommandLine)
+	exportCfg.registerFlags(flag.CommandLine)
+	historyCfg.registerFlags(flag.CommandLine)
+	exposureCfg.registerFlags(flag.CommandLine)
+	flag.StringVar(&tuningFile, "detection-config", "", "JSON file to persist detection parameters tuned with PUT /config/detection to, and load them from at startup")
+	flag.StringVar(&rulesFile, "rules", "", "JSON file of automation rules (CEL conditions and webhooks)")
+	bleCfg.registerFlags(flag.CommandLine)
+	netCfg.registerFlags(flag.CommandLine)
+	audioCfg.registerFlags(flag.CommandLine)
+	idleCfg.registerFlags(flag.CommandLine)
+	influxCfg.registerFlags(flag.CommandLine)
+	postgresCfg.registerFlags(flag.CommandLine)
+	gpioCfg.registerFlags(flag.CommandLine)
+	maskCfg.registerFlags(flag.CommandLine)
+	exprCfg.registerFlags(flag.CommandLine)
+	scene.cfg.registerFlags(flag.CommandLine)
+	wolCfg.registerFlags(flag.CommandLine)
+	remoteCfg.registerFlags(flag.CommandLine)
+	relayCfg.registerFlags(flag.CommandLine)
+	annotateCfg.registerFlags(flag.CommandLine)
+	focusCfg.registerFlags(flag.CommandLine)
+	zoneCfg.registerFlags(flag.CommandLine)
+	flag.Float64Var(&cameraWeight, "camera-weight", cameraWeight, "weight of a camera face detection in the fusion policy")
+	flag.DurationVar(&cameraFreshness, "camera-freshness", cameraFreshness, "freshness window: how long a camera face detection counts towards presence")
+	flag.Float64Var(&fusionThreshold, "fusion-threshold", fusionThreshold, "total weight of signals needed for presence to be detected")
+	flag.Var(&scheduleSpecs, "schedule", "window when capture is active, like \"Mon-Fri 08:00-18:00\" (a window ending when it starts lasts 24 hours); may be repeated (default always active)")
+	flag.StringVar(&scheduleTZ, "timezone", "", "timezone for -schedule, -away-timeout-at, and -detection-profiles (default local time)")
+	flag.Float64Var(&streamFPS, "stream-fps", streamFPS, "frame rate for live streams")
+	flag.Float64Var(&boxSmoothing, "box-smoothing", boxSmoothing, "how much of a detection's box in the previous frame is kept, smoothing jitter between frames: 0 (no smoothing) to less than 1")
+	flag.BoolVar(&equalize, "equalize", false, "equalize frames' histograms before detection, to help find faces in dim or flat light")
+	profilesCfg.registerFlags(flag.CommandLine)
+	limitCfg.registerFlags(flag.CommandLine)
+	quotaCfg.registerFlags(flag.CommandLine)
+	flag.Var(&resizeWidths, "widths", "comma-separated widths that snapshots and streams can be requested at, with ?width=")
+	hlsCfg.registerFlags(flag.CommandLine)
+	loopbackCfg.registerFlags(flag.CommandLine)
+	flag.Var(&iceServers, "webrtc-ice-server", "STUN/TURN server URL for WebRTC streams, like stun:stun.l.google.com:19302; may be repeated")
+	if err := applyEnv(flag.CommandLine); err != nil {
+		return err
+	}
+	flag.Parse()
+
+	if containerMode {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		if !set["listen"] {
+			listenAddr = ":8888"
+		}
+		if !set["log-format"] {
+			logCfg.format = "json"
+		}
+		if !set["cascades"] {
+			detectorCfg.CascadesDir = ""
+
+			if !set["classifiers"] {
+				detectorCfg.Classifiers = defaultClassifiers("")
+			}
+		}
+	}
+
+	var closeLog func()
+	closeLog, err = setupLogging(logCfg)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	activeSchedule, err = parseSchedule(scheduleSpecs, scheduleTZ)
+	if err != nil {
+		return err
+	}
+
+	if err := detectorCfg.faceSize.validate(); err != nil {
+		return err
+	}
+
+	if boxSmoothing < 0 || boxSmoothing >= 1 {
+		return fmt.Errorf("invalid -box-smoothing %v: must be at least 0 and less than 1", boxSmoothing)
+	}
+
+	if err := exposureCfg.validate(); err != nil {
+		return err
+	}
+	exposure.cfg = exposureCfg
+
+	annotation, err = annotateCfg.style()
+	if err != nil {
+		return err
+	}
+
+	if authCfg.enabled() {
+		apiKeys, err = loadAPIKeys(authCfg.file)
+		if err != nil {
+			return fmt.Errorf("loading API keys: %w", err)
+		}
+	}
+
+	if auditCfg.enabled() {
+		var key []byte
+		if auditCfg.keyFile != "" {
+			if key, err = readAuditKey(auditCfg.keyFile); err != nil {
+				return err
+			}
+		}
+
+		audit, err = openAuditLog(auditCfg.file, key)
+		if err != nil {
+			return err
+		}
+		defer audit.close()
+	}
+
+	if recordCfg.enabled() {
+		recorder = newDetectionRecorder(recordCfg)
+		defer recorder.close()
+	}
+
+	if exportCfg.enabled() {
+		if err := exportCfg.validate(); err != nil {
+			return err
+		}
+
+		exporter, err = newDatasetExporter(exportCfg)
+		if err != nil {
+			return fmt.Errorf("preparing -export: %w", err)
+		}
+		defer func() {
+			webcamMu.Lock()
+			defer webcamMu.Unlock()
+
+			if err := exporter.close(); err != nil {
+				slog.Error("writing exported annotations", "err", err)
+			}
+		}()
+	}
+
+	if streamFPS <= 0 {
+		return fmt.Errorf("invalid -stream-fps %v: must be positive", streamFPS)
+	}
+	streamFrames = newFrameHub(streamFPS)
+	imageLimiter = newRequestLimiter(limitCfg)
+
+	if err := quotaCfg.validate(); err != nil {
+		return err
+	}
+	frameQuota.limit = quotaCfg.maxPerHour
+
+	if hlsCfg.enabled() {
+		if _, err := exec.LookPath(hlsCfg.ffmpeg); err != nil {
+			return fmt.Errorf("HLS needs ffmpeg: %w", err)
+		}
+		hlsStream = newHLSSegmenter(hlsCfg, streamFPS, streamFrames)
+	}
+
+	if loopbackCfg.enabled() {
+		if err := loopbackCfg.validate(); err != nil {
+			return err
+		}
+		if _, err := exec.LookPath(hlsCfg.ffmpeg); err != nil {
+			return fmt.Errorf("-v4l2-output needs ffmpeg: %w", err)
+		}
+	}
+
+	timeouts, err := parseAwayTimeouts(awayTimeoutSpecs, scheduleTZ, awayTimeout)
+	if err != nil {
+		return err
+	}
+
+	tracker = newPresenceTracker(redactURL(device), timeouts, cameraWeight, cameraFreshness, fusionThreshold)
+	tracker.addNotifier(events)
+
+	if err := historyCfg.validate(); err != nil {
+		return err
+	}
+
+	history, err = newPresenceHistory(historyCfg)
+	if err != nil {
+		return fmt.Errorf("loading presence history: %w", err)
+	}
+	defer func() {
+		if err := history.close(tracker.status()); err != nil {
+			slog.Error("closing presence history", "err", err)
+		}
+	}()
+	tracker.addNotifier(history)
+
+	if zoneCfg.enabled() {
+		zones, err := parseZones(zoneCfg.specs)
+		if err != nil {
+			return err
+		}
+		tracker.setZones(zones)
+		tracker.addZoneNotifier(events)
+	}
+
+	if emailCfg.enabled() {
+		n, err := newEmailNotifier(emailCfg)
+		if err != nil {
+			return fmt.Errorf("configuring email alerts: %w", err)
+		}
+		tracker.addNotifier(emailCfg.throttle.throttle("email", n))
+	}
+
+	if templateCfg.enabled() {
+		n, err := newTemplateNotifier(templateCfg)
+		if err != nil {
+			return fmt.Errorf("configuring templated output: %w", err)
+		}
+		tracker.addNotifier(templateCfg.throttle.throttle("template", n))
+	}
+
+	if pluginsDir != "" {
+		n, err := newPluginNotifier(pluginsDir)
+		if err != nil {
+			return fmt.Errorf("configuring plugins: %w", err)
+		}
+		// zones are throttled independently, so they can share a throttle
+		tn := pluginsThrottle.throttle("plugins", n)
+		tracker.addNotifier(tn)
+		tracker.addZoneNotifier(tn)
+	}
+
+	if bleCfg.enabled() {
+		ble := newBLEScanner(bleCfg)
+		tracker.addSignal("ble", ble, bleCfg.weight, bleCfg.timeout)
+
+		go func() {
+			if err := ble.scan(); err != nil {
+				slog.Error("Bluetooth scanning stopped", "err", err)
+			}
+		}()
+	}
+
+	if netCfg.enabled() {
+		if err := netCfg.validate(); err != nil {
+			return err
+		}
+
+		ns := newNetworkScanner(netCfg)
+		tracker.addSignal("network", ns, netCfg.weight, netCfg.timeout)
+
+		go ns.watch(ctx)
+	}
+
+	if audioCfg.enabled() {
+		ad := newAudioDetector(audioCfg)
+
+		stop, err := ad.listen()
+		if err != nil {
+			return err
+		}
+		defer stop()
+
+		tracker.addSignal("audio", ad, audioCfg.weight, audioCfg.timeout)
+	}
+
+	// keyboard/mouse input is also needed for the Focus mode's attention
+	var im *idleMonitor
+	if idleCfg.enabled() || (focusCfg.enabled() && focusCfg.attention > 0) {
+		im = newIdleMonitor()
+
+		go im.watch(ctx)
+	}
+
+	if idleCfg.enabled() {
+		tracker.addSignal("idle", im, idleCfg.weight, idleCfg.timeout)
+	}
+
+	if rulesFile != "" {
+		rules, err := loadRules(rulesFile, tracker)
+		if err != nil {
+			return fmt.Errorf("loading rules: %w", err)
+		}
+		tracker.addNotifier(rules)
+
+		go rules.watch(ctx)
+	}
+
+	if influxCfg.enabled() {
+		ie, err := newInfluxExporter(influxCfg, tracker)
+		if err != nil {
+			return fmt.Errorf("configuring InfluxDB export: %w", err)
+		}
+
+		go ie.watch(ctx)
+	}
+
+	if postgresCfg.enabled() {
+		ps, err := newPostgresSink(ctx, postgresCfg, tracker)
+		if err != nil {
+			return fmt.Errorf("configuring PostgreSQL sink: %w", err)
+		}
+		defer ps.close()
+		tracker.addNotifier(ps)
+
+		go ps.watch(ctx)
+		go ps.maintain(ctx)
+	}
+
+	if gpioCfg.enabled() {
+		gn, err := newGPIONotifier(gpioCfg)
+		if err != nil {
+			return fmt.Errorf("configuring GPIO output: %w", err)
+		}
+		defer gn.close()
+		tracker.addNotifier(gn)
+	}
+
+	if wolCfg.enabled() {
+		n, err := newWOLNotifier(wolCfg)
+		if err != nil {
+			return fmt.Errorf("configuring Wake-on-LAN: %w", err)
+		}
+		tracker.addNotifier(n)
+	}
+
+	if focusCfg.enabled() {
+		fc, err := newFocusController(focusCfg, tracker, im)
+		if err != nil {
+			return fmt.Errorf("configuring Focus mode: %w", err)
+		}
+		tracker.addNotifier(fc)
+
+		go fc.watch(ctx)
+	}
+
+	// deferred after the outputs, so events still settling or being sent
+	// are done with before they're closed
+	defer tracker.close()
+
+	if remoteCfg.enabled() {
+		remotes, err = newRemoteInstances(remoteCfg)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range remotes {
+			tracker.addSignal("remote:"+r.name, r, remoteCfg.weight, remoteCfg.freshness)
+
+			go r.watch(ctx, remoteCfg.interval)
+		}
+
+		if remoteCfg.only {
+			go aggregate(ctx, remoteCfg.interval)
+		}
+	} else if remoteCfg.only {
+		return fmt.Errorf("-aggregate-only needs at least one -remote")
+	}
+
+	if relayCfg.enabled() {
+		if err := serveRelay(ctx, relayCfg); err != nil {
+			return err
+		}
+	} else if device == relayDevice {
+		return fmt.Errorf("-device=%s needs -relay-listen", relayDevice)
+	}
+
+	if device == stdinDevice {
+		if err := piped.start(stdinCfg, os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	if loopbackCfg.enabled() {
+		lw := &loopbackWriter{cfg: loopbackCfg, ffmpeg: hlsCfg.ffmpeg, fps: streamFPS, frames: streamFrames}
+
+		go lw.run(ctx)
+	}
+
+	// Open webcam, unless we're starting outside the schedule (or there's no
+	// local camera)
+	if activeSchedule.active(time.Now()) && !remoteCfg.only {
+		if err := openWebcam(); err != nil {
+			return err
+		}
+	}
+	defer closeWebcam()
+
+	detectorCfg.MaskModel, detectorCfg.ExpressionModel = maskCfg.model, exprCfg.model
+	if err := setDetector(detectorCfg); err != nil {
+		return err
+	}
+	defer func() {
+		webcamMu.Lock()
+		defer webcamMu.Unlock()
+
+		det.close()
+	}()
+
+	watchInterval = detectInterval
+	if tuningFile != "" {
+		if err := loadTuning(tuningFile); err != nil {
+			return fmt.Errorf("loading detection parameters: %w", err)
+		}
+	}
+
+	if profilesCfg.enabled() {
+		profiles, err = newProfileSwitcher(profilesCfg, scheduleTZ)
+		if err != nil {
+			return fmt.Errorf("loading detection profiles: %w", err)
+		}
+
+		go profiles.watch(ctx)
+	}
+
+	if detectInterval > 0 {
+		if sdWatchdog != nil && detectInterval >= sdWatchdog.interval {
+			slog.Warn("Detection interval is too long for the systemd watchdog", "interval", detectInterval, "watchdog", 2*sdWatchdog.interval)
+		}
+
+		go watch(watchInterval)
+	} else {
+		go sdWatchdog.run()
+	}
+
+	if grpcAddr != "" {
+		l, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			return fmt.Errorf("listening for gRPC: %w", err)
+		}
+
+		srv := newGRPCServer(events)
+		defer srv.Stop()
+
+		go func() {
+			if err := srv.Serve(l); err != nil {
+				slog.Error("gRPC server stopped", "err", err)
+			}
+		}()
+
+		slog.Info("gRPC API listening at " + l.Addr().String())
+	}
+
+	// Set up HTTP server, with routes from the OpenAPI spec
+	mux, err := newAPIMux(openAPISpec, apiHandlers)
 	if err != nil {
-		return fmt.Errorf("opening capture device %d: %w", deviceID, err)
+		return err
 	}
-	defer webcam.Close()
 
-	classifierPath := "/opt/homebrew/Cellar/opencv/4.9.0_4/share/opencv4"
-	haarClassifierPath := filepath.Join(classifierPath, "haarcascades")
-	lbpClassifierPath := filepath.Join(classifierPath, "lbpcascades")
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
 
-	// Load Haar Cascade Classifier for face detection
-	haarFaceCascade = gocv.NewCascadeClassifier()
-	defer haarFaceCascade.Close()
-	if !haarFaceCascade.Load(filepath.Join(haarClassifierPath, "haarcascade_frontalface_default.xml")) {
-		return fmt.Errorf("loading Haar face classifier: %w", err)
+	// cancelling the base context on shutdown ends long-lived requests, like
+	// event streams
+	srv := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
-	// Load Eye Classifier
-	eyeCascade = gocv.NewCascadeClassifier()
-	defer eyeCascade.Close()
-	if !eyeCascade.Load(filepath.Join(haarClassifierPath, "haarcascade_eye.xml")) {
-		return fmt.Errorf("loading Haar eye classifier: %w", err)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	slog.Info("Server listening at http://" + listenAddr + "/")
+
+	health.setReady(true)
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("notifying systemd", "err", err)
 	}
 
-	// Load LBP Cascade Classifier for face detection
-	lbpFaceCascade = gocv.NewCascadeClassifier()
-	defer lbpFaceCascade.Close()
-	if !lbpFaceCascade.Load(filepath.Join(lbpClassifierPath, "lbpcascade_frontalface_improved.xml")) {
-		return fmt.Errorf("loading LBP face classifier: %w", err)
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
 	}
 
-	slog.Info("Server listening at http://127.0.0.1:8888/")
+	slog.Info("Shutting down")
+	health.setReady(false)
+	_ = sdNotify("STOPPING=1")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Set up HTTP server
-	http.HandleFunc("/", handleRequest)
-	return http.ListenAndServe("127.0.0.1:8888", nil)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down HTTP server: %w", err)
+	}
+
+	return nil
+}
+
+// watch periodically captures and analyzes a frame so that presence changes
+// are noticed even when no-one is requesting images. The interval can be
+// changed through watchIntervals.
+func watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case d := <-watchIntervals:
+			ticker.Reset(d)
+			continue
+		case <-ticker.C:
+		}
+
+		imgMat := gocv.NewMat()
+		_, _, err := analyzeFrame(&imgMat, nil)
+		if err != nil && !captureSkipped(err) {
+			slog.Warn("background detection failed", "err", err)
+		} else {
+			// only while capture is healthy, so a failing camera gets the
+			// service restarted
+			sdWatchdog.ping()
+			health.loopOK()
+		}
+		imgMat.Close()
+	}
 }
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
-	imgMat := gocv.NewMat()
-	defer imgMat.Close()
+	width, err := requestWidth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the frame is tagged with its scene generation - as long as the
+	// background detection loop keeps the scene current, a request for the
+	// latest generation can skip the capture
+	clean := r.URL.Query().Get("annotate") == "false"
+
+	etagSuffix := ""
+	if width > 0 {
+		etagSuffix = "-w" + strconv.Itoa(width)
+	}
+	if clean {
+		etagSuffix += "-clean"
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if detectInterval > 0 && notModified(w, r, frameETag(scene.generation(), etagSuffix)) {
+		return
+	}
+
+	// the quota is taken before capturing, so refused requests cost nothing,
+	// and given back when no frame is served after all
+	taken := time.Now()
+	if ok, retry := frameQuota.take("snapshot", taken); !ok {
+		w.Header().Del("ETag")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, errFrameQuota.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	// Capture and convert to JPEG format
+	frame, err := captureFrame([]int{width}, clean)
+	if err != nil {
+		frameQuota.refund("snapshot", taken)
+
+		if captureSkipped(err) {
+			writePlaceholder(w, err, width)
+			return
+		}
 
-	if ok := webcam.Read(&imgMat); !ok {
-		fmt.Printf("Device closed: %v\n", deviceID)
+		slog.Error("capturing frame", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Write the (possibly shared) JPEG to the response as-is
+	w.Header().Set("ETag", frameETag(frame.gen, etagSuffix))
+	w.Header().Set("Content-Type", "image/jpeg")
+	setDetectionsHeader(w, frame, width)
+	_, err = w.Write(frame.jpegs[width])
+	if err != nil {
+		slog.Debug("writing image to response", "err", err)
+	}
+}
+
+// writePlaceholder responds with a placeholder image in place of a camera
+// frame, when capture isn't happening.
+func writePlaceholder(w http.ResponseWriter, reason error, width int) {
+	imgs, err := placeholderFrames(placeholderMessage(reason), []int{width})
+	if err != nil {
+		http.Error(w, reason.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Del("ETag")
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(imgs[width])
+}
+
+// captureJPEG captures and analyzes a frame, returning the annotated frame as
+// a JPEG, scaled down to width (0 for full size).
+func captureJPEG(width int) ([]byte, error) {
+	imgs, err := captureJPEGs([]int{width})
+	if err != nil {
+		return nil, err
+	}
+
+	return imgs[width], nil
+}
+
+// captureFlight shares a single capture between concurrent requests for the
+// same widths
+var captureFlight singleflight.Group
+
+// captureJPEGs captures and analyzes a single frame, returning the annotated
+// frame as a JPEG at each of the given widths. Concurrent calls for the same
+// widths share a capture. The returned map must not be modified.
+func captureJPEGs(widths []int) (map[int][]byte, error) {
+	frame, err := captureFrame(widths, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame.jpegs, nil
+}
+
+// capturedFrame is a captured and analyzed frame.
+type capturedFrame struct {
+	// gen is the frame's scene generation
+	gen uint64
+	// jpegs are the frame's JPEGs, by width
+	jpegs map[int][]byte
+	// detections are the faces found in the full-size frame
+	detections []faceDetection
+	// width is the width of the full-size frame
+	width int
+}
+
+// captureFrame captures and analyzes a single frame, encoding it as a JPEG at
+// each of the given widths, annotated unless clean is set. Concurrent calls
+// for the same widths share a capture. The result must not be modified.
+func captureFrame(widths []int, clean bool) (*capturedFrame, error) {
+	captured := false
+	v, err, _ := captureFlight.Do(fmt.Sprint(widths, clean), func() (any, error) {
+		captured = true
+
+		imgMat := gocv.NewMat()
+		defer imgMat.Close()
+
+		var cleanMat *gocv.Mat
+		if clean {
+			m := gocv.NewMat()
+			defer m.Close()
+			cleanMat = &m
+		}
+
+		gen, faces, err := analyzeFrame(&imgMat, cleanMat)
+		if err != nil {
+			return nil, err
+		}
+
+		src := imgMat
+		if clean {
+			src = *cleanMat
+		}
+
+		start := time.Now()
+		imgs, err := scene.encode(gen, src, widths, clean)
+		if err != nil {
+			return nil, fmt.Errorf("encoding frame: %w", err)
+		}
+		encodeStage.timed(start)
+
+		return &capturedFrame{gen: gen, jpegs: imgs, detections: faces, width: imgMat.Cols()}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !captured {
+		// shared with a concurrent capture, so nothing was encoded
+		scene.hits.Add(uint64(len(widths)))
+	}
+
+	return v.(*capturedFrame), nil
+}
+
+// analyzeFrame reads a frame from the webcam into imgMat, annotates it with
+// the detected faces and eyes, and feeds the result to the presence tracker.
+// When clean isn't nil, the unannotated frame is copied into it. When the
+// scene hasn't changed, the last frame's results are reused. It returns the
+// scene generation, for caching the encoded frame, and the detected faces.
+func analyzeFrame(imgMat, clean *gocv.Mat) (uint64, []faceDetection, error) {
+	now, err := readFrame(imgMat)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	gen, faces := detectFrame(imgMat, clean, now)
+
+	return gen, faces, nil
+}
+
+// readFrame is the capture stage of the frame pipeline: it reads a frame from
+// the webcam into imgMat, opening or releasing the webcam according to the
+// schedule, and returns when it was read.
+func readFrame(imgMat *gocv.Mat) (time.Time, error) {
+	webcamMu.Lock()
+	defer webcamMu.Unlock()
+
+	if remoteCfg.only {
+		return time.Time{}, errNoCamera
+	}
+
+	if paused {
+		return time.Time{}, errPaused
+	}
+
+	if !activeSchedule.active(time.Now()) {
+		if webcam != nil {
+			slog.Info("Outside of schedule, releasing camera")
+			closeWebcam()
+		}
+
+		return time.Time{}, errOutsideSchedule
+	}
+
+	if webcam == nil {
+		slog.Info("Inside schedule, opening camera")
+		if err := openWebcam(); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	defer captureStage.timed(time.Now())
+
+	if ok := webcam.Read(imgMat); !ok {
+		return time.Time{}, fmt.Errorf("device closed: %v", redactURL(device))
+	}
+
+	return time.Now(), nil
+}
+
+// detectFrame is the detect stage of the frame pipeline: it annotates imgMat
+// (read at now) with the detected faces and eyes, and feeds the result to the
+// presence tracker, like analyzeFrame.
+func detectFrame(imgMat, clean *gocv.Mat, now time.Time) (uint64, []faceDetection) {
+	webcamMu.Lock()
+	defer webcamMu.Unlock()
+
+	defer detectStage.timed(time.Now())
+
+	frameHash := recorder.hash(*imgMat)
+	exposure.observe(*imgMat, now)
+
+	faces, thumb, ok := scene.reuse(imgMat, clean, now)
+	gen := scene.generation()
+	if !ok {
+		unannotated := imgMat.Clone()
+		if clean != nil {
+			unannotated.CopyTo(clean)
+		}
+
+		faces = detectFaces(imgMat)
+		exporter.export(unannotated, faces, now)
+		gen = scene.store(thumb, unannotated, *imgMat, faces, now)
+	}
+
+	// frames are read and detected under separate locks, so a frame can be
+	// overtaken by a newer one - it's still annotated for its caller, but
+	// not observed, so the tracker only moves forward
+	if now.Before(lastDetected) {
+		return gen, faces
+	}
+	lastDetected = now
+
+	tracker.observe(now, image.Pt(imgMat.Cols(), imgMat.Rows()), faces, func() ([]byte, error) {
+		imgs, err := scene.encode(gen, *imgMat, []int{0}, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return imgs[0], nil
+	})
+
+	recorder.record(detectionRecord{
+		Time:       now,
+		Camera:     tracker.camera,
+		Width:      imgMat.Cols(),
+		Height:     imgMat.Rows(),
+		Frame:      frameHash,
+		Reused:     ok,
+		Detections: faces,
+	})
+
+	return gen, faces
+}
+
+// frameSource is a capture device - a camera or stream, the relay, or stdin.
+type frameSource interface {
+	Read(m *gocv.Mat) bool
+	Close() error
+}
+
+// openWebcam opens the capture device. Callers other than run must hold
+// webcamMu.
+func openWebcam() error {
+	if device == relayDevice {
+		webcam = relayed
+		return nil
+	}
+
+	if device == stdinDevice {
+		webcam = piped
+		return nil
+	}
+
+	webcam, err = gocv.OpenVideoCapture(device)
+	if err != nil {
+		webcam = nil
+		return fmt.Errorf("opening capture device %s: %w", redactURL(device), err)
+	}
+
+	return nil
+}
+
+// redactURL hides any password in a stream URL, so it isn't logged or sent
+// with events.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+
+	return u.Redacted()
+}
+
+// closeWebcam releases the capture device, if it's open. Callers other than
+// run must hold webcamMu.
+func closeWebcam() {
+	if webcam == nil {
+		return
+	}
+
+	webcam.Close()
+	webcam = nil
+	scene.reset()
+	exposure.reset()
+}
+
+// detectFaces annotates imgMat with the faces and eyes found by the
+// classifiers, and returns the faces found by those counting faces, within
+// their size bounds. A face found by more than one classifier is only counted
+// once.
+func detectFaces(imgMat *gocv.Mat) []faceDetection {
 	// Convert to grayscale for detection
 	gray := gocv.NewMat()
 	defer gray.Close()
-	gocv.CvtColor(imgMat, &gray, gocv.ColorBGRToGray)
-
-	// first detect faces using the Haar frontal face classifier
-	rects := haarFaceCascade.DetectMultiScale(gray)
-	for _, r := range rects {
-		if r.Size().X > minFaceSize && r.Size().X < maxFaceSize {
-			gocv.Rectangle(&imgMat, r, color.RGBA{0, 255, 0, 0}, 2)
-
-			sizeText := fmt.Sprintf("Size: %dx%d", r.Size().X, r.Size().Y)
-			gocv.PutText(&imgMat, sizeText, image.Pt(r.Min.X, r.Min.Y-10), font, 1.0, color.RGBA{0, 255, 0, 0}, 2)
-
-			// Detect eyes within the face region
-			roiMat := imgMat.Region(r)
-			defer roiMat.Close()
-			eyes := eyeCascade.DetectMultiScale(roiMat)
-			for _, eyeRect := range eyes {
-				eyeRect.Min.X += r.Min.X
-				eyeRect.Min.Y += r.Min.Y
-				eyeRect.Max.X += r.Min.X
-				eyeRect.Max.Y += r.Min.Y
-				gocv.Rectangle(&imgMat, eyeRect, color.RGBA{0, 0, 255, 0}, 2)
-			}
+	gocv.CvtColor(*imgMat, &gray, gocv.ColorBGRToGray)
+	if equalize {
+		gocv.EqualizeHist(gray, &gray)
+	}
+
+	// detect everything before annotating, so the annotations don't confuse
+	// the classifiers
+	type found struct {
+		c *cascade
+		r image.Rectangle
+	}
+
+	mirrored := gocv.NewMat()
+	defer mirrored.Close()
+	if slices.ContainsFunc(det.faces, func(c *cascade) bool { return c.Mirror }) {
+		gocv.Flip(gray, &mirrored, 1)
+	}
+
+	all := []found{}
+	for _, c := range det.faces {
+		for _, r := range c.detect(gray, mirrored) {
+			all = append(all, found{c, r})
 		}
 	}
 
-	// then detect faces using the LBP frontal face classifier
-	rects = lbpFaceCascade.DetectMultiScale(gray)
-	for _, r := range rects {
-		// if r.Size().X > minFaceSize && r.Size().X < maxFaceSize {
-		gocv.Rectangle(&imgMat, r, color.RGBA{255, 0, 0, 0}, 2)
+	faces := []faceDetection{}
+	counted := []image.Rectangle{}
+
+	for _, f := range all {
+		r := f.r
+		label := labelData{Classifier: f.c.Name, Width: r.Dx(), Height: r.Dy()}
+
+		if !f.c.Faces || slices.ContainsFunc(counted, func(o image.Rectangle) bool { return overlaps(r, o) }) {
+			annotation.draw(imgMat, r, f.c.rgba, label)
+
+			continue
+		}
+
+		counted = append(counted, r)
+		d := newFaceDetection(r, f.c.Name)
 
-		sizeText := fmt.Sprintf("Size: %dx%d", r.Size().X, r.Size().Y)
-		gocv.PutText(&imgMat, sizeText, image.Pt(r.Min.X, r.Min.Y-10), font, 1.0, color.RGBA{255, 0, 0, 0}, 2)
-		// }
+		// classify before annotating, so the overlay doesn't confuse the
+		// classifier
+		if det.mask != nil {
+			masked, p, err := det.mask.masked(*imgMat, r)
+			if err != nil {
+				slog.Warn("classifying face covering", "err", err)
+			} else {
+				d.Masked = &masked
+				d.MaskProbability = p
+
+				label.MaskKnown, label.Masked, label.MaskConfidence = true, masked, p
+				if !masked {
+					label.MaskConfidence = 1 - p
+				}
+			}
+		}
+
+		if det.expr != nil {
+			expr, p, err := det.expr.expression(*imgMat, r)
+			if err != nil {
+				slog.Warn("classifying expression", "err", err)
+			} else {
+				d.Expression, d.ExpressionProbability = expr, p
+				label.Expression, label.ExpressionProbability = expr, p
+			}
+		}
+
+		faces = append(faces, d)
+
+		annotation.draw(imgMat, r, f.c.rgba, label)
+
+		if det.eye == nil {
+			continue
+		}
+
+		// Detect eyes within the face region
+		roiMat := imgMat.Region(r)
+		eyes := det.eye.classifier.DetectMultiScale(roiMat)
+		roiMat.Close()
+		for _, eyeRect := range eyes {
+			gocv.Rectangle(imgMat, eyeRect.Add(r.Min), det.eye.rgba, annotation.thickness)
+		}
 	}
 
-	// Convert gocv.Mat to JPEG format
+	return faces
+}
+
+// overlaps reports whether most of the smaller of two rectangles is covered
+// by the other.
+func overlaps(a, b image.Rectangle) bool {
+	in := a.Intersect(b)
+	smaller := min(a.Dx()*a.Dy(), b.Dx()*b.Dy())
+
+	return smaller > 0 && in.Dx()*in.Dy()*2 > smaller
+}
+
+// encodeJPEG encodes the image as a JPEG, returning a regular Go slice.
+func encodeJPEG(imgMat gocv.Mat) ([]byte, error) {
 	buf, err := gocv.IMEncode(".jpg", imgMat)
 	if err != nil {
-		fmt.Println("Error encoding frame:", err)
-		return
+		return nil, err
 	}
+	defer buf.Close()
 
 	// Create a regular Go slice from the NativeByteBuffer
 	bufSlice := make([]byte, buf.Len())
 	copy(bufSlice, buf.GetBytes())
 
-	// Create image.Image from encoded buffer
-	out, _, err := image.Decode(bytes.NewReader(bufSlice))
-	if err != nil {
-		fmt.Println("Error decoding frame:", err)
-		return
+	return bufSlice, nil
+}
+
+// encodeJPEGs encodes the image as a JPEG at each of the given widths, keeping
+// the aspect ratio. A width of 0 means full size, and images are never scaled
+// up.
+func encodeJPEGs(imgMat gocv.Mat, widths []int) (map[int][]byte, error) {
+	imgs := make(map[int][]byte, len(widths))
+
+	for _, width := range widths {
+		if width <= 0 || width >= imgMat.Cols() {
+			img, err := encodeJPEG(imgMat)
+			if err != nil {
+				return nil, err
+			}
+
+			imgs[width] = img
+			continue
+		}
+
+		height := imgMat.Rows() * width / imgMat.Cols()
+
+		scaled := gocv.NewMat()
+		gocv.Resize(imgMat, &scaled, image.Pt(width, height), 0, 0, gocv.InterpolationArea)
+		img, err := encodeJPEG(scaled)
+		scaled.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		imgs[width] = img
 	}
 
-	// Write image to response
-	w.Header().Set("Content-Type", "image/jpeg")
-	err = jpeg.Encode(w, out, nil)
+	return imgs, nil
+}
+
+// requestWidth returns the width requested with the width query parameter,
+// which must be one of the configured widths. It's 0 when no width is given.
+func requestWidth(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("width")
+	if v == "" {
+		return 0, nil
+	}
+
+	width, err := strconv.Atoi(v)
 	if err != nil {
-		fmt.Println("Error writing image to response:", err)
+		return 0, fmt.Errorf("invalid width %q", v)
 	}
+
+	if !slices.Contains(resizeWidths, width) {
+		return 0, fmt.Errorf("unsupported width %d, must be one of %s", width, resizeWidths.String())
+	}
+
+	return width, nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// maskConfig holds the settings for the optional face-covering classifier.
+type maskConfig struct {
+	model     string
+	config    string
+	inputSize int
+	class     int
+	threshold float64
+}
+
+func (c *maskConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.model, "mask-model", "", "DNN model (ONNX, Caffe, TensorFlow, ...) classifying faces as masked or not (default disabled)")
+	fs.StringVar(&c.config, "mask-model-config", "", "config file for -mask-model, for frameworks that need one")
+	fs.IntVar(&c.inputSize, "mask-input-size", 224, "width and height of the -mask-model input, in pixels")
+	fs.IntVar(&c.class, "mask-class", 0, "index of the \"mask\" probability in the -mask-model output")
+	fs.Float64Var(&c.threshold, "mask-threshold", 0.5, "probability above which a face is considered masked")
+}
+
+func (c maskConfig) enabled() bool {
+	return c.model != ""
+}
+
+// maskClassifier labels faces as wearing a mask (or other face covering) or
+// not. Callers must hold webcamMu.
+type maskClassifier struct {
+	*faceClassifier
+	class     int
+	threshold float64
+}
+
+func newMaskClassifier(cfg maskConfig) (*maskClassifier, error) {
+	if cfg.class < 0 {
+		return nil, fmt.Errorf("invalid -mask-class %d", cfg.class)
+	}
+
+	fc, err := newFaceClassifier(cfg.model, cfg.config, cfg.inputSize, false)
+	if err != nil {
+		return nil, fmt.Errorf("mask classifier: %w", err)
+	}
+
+	return &maskClassifier{faceClassifier: fc, class: cfg.class, threshold: cfg.threshold}, nil
+}
+
+// masked returns whether the face in the given region of img is masked, and
+// the probability that it is.
+func (m *maskClassifier) masked(img gocv.Mat, r image.Rectangle) (bool, float64, error) {
+	probs := m.classify(img, r)
+	if m.class >= len(probs) {
+		return false, 0, fmt.Errorf("mask model has %d outputs, so -mask-class %d is out of range", len(probs), m.class)
+	}
+
+	p := probs[m.class]
+
+	return p > m.threshold, p, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// detectionsHeader carries a served frame's detections, as JSON
+const detectionsHeader = "X-Presence-Detections"
+
+// frameMeta describes an analyzed frame, for clients drawing their own
+// overlays on the clean frame.
+type frameMeta struct {
+	// Frame identifies the analyzed frame - it's the same for responses
+	// reusing an unchanged scene
+	Frame  uint64    `json:"frame"`
+	Time   time.Time `json:"time"`
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+	// Detections are in full-size frame coordinates
+	Detections []faceDetection `json:"detections"`
+}
+
+// setDetectionsHeader sets the detections header to the frame's detections,
+// scaled to the served width (0 for full size). Like encodeJPEGs, widths at or
+// above the frame's are served full size.
+func setDetectionsHeader(w http.ResponseWriter, frame *capturedFrame, width int) {
+	dets := frame.detections
+	if width > 0 && width < frame.width {
+		f := float64(width) / float64(frame.width)
+
+		dets = make([]faceDetection, len(frame.detections))
+		for i, d := range frame.detections {
+			dets[i] = d.scaled(f)
+		}
+	}
+
+	if dets == nil {
+		dets = []faceDetection{}
+	}
+
+	b, err := json.Marshal(dets)
+	if err != nil {
+		slog.Warn("encoding detections header", "err", err)
+		return
+	}
+
+	w.Header().Set(detectionsHeader, string(b))
+}
+
+// handleSnapshotMeta responds with the metadata of the last analyzed frame,
+// without capturing a new one.
+func handleSnapshotMeta(w http.ResponseWriter, _ *http.Request) {
+	meta := scene.metadata()
+	if meta.Frame == 0 {
+		http.Error(w, "no frame analyzed yet", http.StatusNotFound)
+		return
+	}
+
+	if meta.Detections == nil {
+		meta.Detections = []faceDetection{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		slog.Debug("writing frame metadata", "err", err)
+	}
+}
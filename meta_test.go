@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetDetectionsHeader(t *testing.T) {
+	frame := &capturedFrame{
+		width:      640,
+		detections: []faceDetection{{X: 100, Y: 60, Width: 200, Height: 200}},
+	}
+
+	tests := []struct {
+		name  string
+		width int
+		want  faceDetection
+	}{
+		{"full size", 0, faceDetection{X: 100, Y: 60, Width: 200, Height: 200}},
+		{"frame width", 640, faceDetection{X: 100, Y: 60, Width: 200, Height: 200}},
+		{"scaled down", 320, faceDetection{X: 50, Y: 30, Width: 100, Height: 100}},
+		// the image isn't scaled up, so neither are the detections
+		{"wider than the frame", 1280, faceDetection{X: 100, Y: 60, Width: 200, Height: 200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setDetectionsHeader(w, frame, tt.width)
+
+			var got []faceDetection
+			if err := json.Unmarshal([]byte(w.Header().Get(detectionsHeader)), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got %+v, want [%+v]", got, tt.want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	setDetectionsHeader(w, &capturedFrame{width: 640}, 320)
+	if got := w.Header().Get(detectionsHeader); got != "[]" {
+		t.Errorf("with no detections, got %q, want []", got)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// status representations
+const (
+	formatJSON       = "json"
+	formatText       = "text"
+	formatPrometheus = "prometheus"
+)
+
+// prometheusContentType is the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// statusFormat picks the status representation from the request's Accept
+// header, honouring q-values. Prometheus asks for text/plain with
+// version=0.0.4 (or OpenMetrics, which it also accepts the text format in
+// place of), while a bare text/plain gets just the state. Anything else gets
+// JSON.
+func statusFormat(accept string) string {
+	type accepted struct {
+		format string
+		q      float64
+	}
+
+	formats := []accepted{}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil || q <= 0 {
+				continue
+			}
+		}
+
+		switch {
+		case mt == "application/openmetrics-text",
+			mt == "text/plain" && params["version"] == "0.0.4":
+			formats = append(formats, accepted{formatPrometheus, q})
+		case mt == "text/plain":
+			formats = append(formats, accepted{formatText, q})
+		case mt == "application/json", mt == "application/*", mt == "*/*":
+			formats = append(formats, accepted{formatJSON, q})
+		}
+	}
+
+	if len(formats) == 0 {
+		return formatJSON
+	}
+
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].q > formats[j].q
+	})
+
+	return formats[0].format
+}
+
+// writeStatusText writes just the state, for shell scripts.
+func writeStatusText(w http.ResponseWriter, st statusResponse) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, st.State+"\n")
+}
+
+// writeStatusPrometheus writes the status as a Prometheus exposition.
+func writeStatusPrometheus(w http.ResponseWriter, st statusResponse, fs fusionStatus, zones []zoneStatus) {
+	w.Header().Set("Content-Type", prometheusContentType)
+
+	camera := `camera="` + promEscape(st.Camera) + `"`
+
+	gauge := func(name, help, labels string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	gauge("presence_known", "Whether anything has been observed yet.", camera, boolGauge(st.Known))
+	gauge("presence_present", "Whether presence is detected.", camera, boolGauge(st.Present))
+	gauge("presence_faces", "Number of faces in the most recent frame.", camera, float64(st.Faces))
+	gauge("presence_paused", "Whether capture has been paused through the API.", "", boolGauge(st.Paused))
+	gauge("presence_scheduled", "Whether capture is inside its schedule.", "", boolGauge(st.Scheduled))
+	gauge("presence_state_since_seconds", "Unix time the current state began.", camera, unixGauge(st.Since))
+	gauge("presence_last_seen_seconds", "Unix time presence was last seen.", camera, unixGauge(st.LastSeen))
+	gauge("presence_fusion_score", "Total weight of the active signals.", "", fs.Score)
+	gauge("presence_fusion_threshold", "Score needed for presence to be seen.", "", fs.Threshold)
+
+	if ex := exposure.status(); ex.Condition != "" {
+		gauge("presence_exposure_brightness", "Mean brightness of recent frames, from 0 to 255.", camera, ex.Brightness)
+		gauge("presence_exposure_contrast", "Standard deviation of recent frames' brightness, from 0 to 255.", camera, ex.Contrast)
+		gauge("presence_exposure_shadows_ratio", "Fraction of recent frames' pixels which are near black.", camera, ex.Shadows)
+		gauge("presence_exposure_highlights_ratio", "Fraction of recent frames' pixels which are near white.", camera, ex.Highlights)
+
+		fmt.Fprintf(w, "# HELP presence_exposure_condition Whether recent frames are in each exposure condition.\n# TYPE presence_exposure_condition gauge\n")
+		for _, c := range exposureConditions {
+			fmt.Fprintf(w, "presence_exposure_condition{%s,condition=\"%s\"} %v\n", camera, c, boolGauge(ex.Condition == c))
+		}
+	}
+
+	counter := func(name, help string, v uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+
+	counter("presence_jpeg_cache_hits_total", "Annotated JPEGs served without encoding, from the cache or a concurrent encode.", scene.hits.Load())
+	counter("presence_jpeg_cache_misses_total", "Annotated JPEGs encoded.", scene.misses.Load())
+
+	fmt.Fprintf(w, "# HELP presence_signal_active Whether each signal is active.\n# TYPE presence_signal_active gauge\n")
+	for _, s := range fs.Signals {
+		fmt.Fprintf(w, "presence_signal_active{signal=\"%s\"} %v\n", promEscape(s.Name), boolGauge(s.Active))
+	}
+
+	fmt.Fprintf(w, "# HELP presence_pipeline_frames_total Frames processed by each stage of the frame pipeline.\n# TYPE presence_pipeline_frames_total counter\n")
+	for _, s := range pipelineStages {
+		fmt.Fprintf(w, "presence_pipeline_frames_total{stage=\"%s\"} %d\n", s.name, s.frames.Load())
+	}
+
+	fmt.Fprintf(w, "# HELP presence_pipeline_dropped_frames_total Frames dropped waiting for each stage of the frame pipeline, because newer frames arrived.\n# TYPE presence_pipeline_dropped_frames_total counter\n")
+	for _, s := range pipelineStages {
+		fmt.Fprintf(w, "presence_pipeline_dropped_frames_total{stage=\"%s\"} %d\n", s.name, s.dropped.Load())
+	}
+
+	fmt.Fprintf(w, "# HELP presence_pipeline_seconds_total Time spent by each stage of the frame pipeline.\n# TYPE presence_pipeline_seconds_total counter\n")
+	for _, s := range pipelineStages {
+		fmt.Fprintf(w, "presence_pipeline_seconds_total{stage=\"%s\"} %s\n", s.name, strconv.FormatFloat(time.Duration(s.busy.Load()).Seconds(), 'g', -1, 64))
+	}
+
+	q := frameQuota.stats(time.Now())
+	gauge("presence_frame_quota", "Most camera frames served through the API in any hour, or 0 for no limit.", "", float64(q.Limit))
+	gauge("presence_frame_quota_used", "Camera frames served through the API in the last hour.", "", float64(q.Used))
+
+	fmt.Fprintf(w, "# HELP presence_frames_served_total Camera frames served through each API endpoint.\n# TYPE presence_frames_served_total counter\n")
+	for _, e := range quotaEndpoints {
+		fmt.Fprintf(w, "presence_frames_served_total{endpoint=\"%s\"} %d\n", e, q.Served[e])
+	}
+
+	fmt.Fprintf(w, "# HELP presence_frames_refused_total Camera frames withheld from each API endpoint, because the hourly frame quota was used up.\n# TYPE presence_frames_refused_total counter\n")
+	for _, e := range quotaEndpoints {
+		fmt.Fprintf(w, "presence_frames_refused_total{endpoint=\"%s\"} %d\n", e, q.Refused[e])
+	}
+
+	if streams := streamFrames.stats(); len(streams) > 0 {
+		writeStreamPrometheus(w, streams)
+	}
+
+	if len(zones) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP presence_zone_present Whether presence is detected in each zone.\n# TYPE presence_zone_present gauge\n")
+	for _, z := range zones {
+		fmt.Fprintf(w, "presence_zone_present{%s,zone=\"%s\"} %v\n", camera, promEscape(z.Name), boolGauge(z.Present))
+	}
+
+	fmt.Fprintf(w, "# HELP presence_zone_faces Number of faces in each zone in the most recent frame.\n# TYPE presence_zone_faces gauge\n")
+	for _, z := range zones {
+		fmt.Fprintf(w, "presence_zone_faces{%s,zone=\"%s\"} %d\n", camera, promEscape(z.Name), z.Faces)
+	}
+}
+
+// writeStreamPrometheus writes each streaming client's queue metrics.
+func writeStreamPrometheus(w http.ResponseWriter, streams []frameSubStats) {
+	labels := func(s frameSubStats) string {
+		return fmt.Sprintf(`stream="%s",client="%s",width="%d"`, promEscape(s.Stream), promEscape(s.Client), s.Width)
+	}
+
+	fmt.Fprintf(w, "# HELP presence_stream_queued_frames Frames waiting to be sent to each streaming client.\n# TYPE presence_stream_queued_frames gauge\n")
+	for _, s := range streams {
+		fmt.Fprintf(w, "presence_stream_queued_frames{%s} %d\n", labels(s), s.Queued)
+	}
+
+	fmt.Fprintf(w, "# HELP presence_stream_frames_sent_total Frames queued for each streaming client.\n# TYPE presence_stream_frames_sent_total counter\n")
+	for _, s := range streams {
+		fmt.Fprintf(w, "presence_stream_frames_sent_total{%s} %d\n", labels(s), s.Sent)
+	}
+
+	fmt.Fprintf(w, "# HELP presence_stream_frames_dropped_total Frames dropped for each streaming client, because it hadn't taken the previous one.\n# TYPE presence_stream_frames_dropped_total counter\n")
+	for _, s := range streams {
+		fmt.Fprintf(w, "presence_stream_frames_dropped_total{%s} %d\n", labels(s), s.Dropped)
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func unixGauge(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// promEscape escapes a Prometheus label value.
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestStatusFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", formatJSON},
+		{"application/json", formatJSON},
+		{"*/*", formatJSON},
+		{"text/html", formatJSON},
+		{"not a media type", formatJSON},
+		{"text/plain", formatText},
+		{"text/plain; charset=utf-8", formatText},
+		{"text/plain;version=0.0.4", formatPrometheus},
+		{"application/openmetrics-text; version=1.0.0", formatPrometheus},
+		// as Prometheus sends it
+		{"application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", formatPrometheus},
+		{"text/plain;q=0.5, application/json", formatJSON},
+		{"application/json;q=0.2, text/plain;q=0.9", formatText},
+		{"text/plain;q=0, application/json;q=0.1", formatJSON},
+		{"text/plain;q=bogus", formatJSON},
+		// equal q-values keep the client's order
+		{"text/plain, text/plain;version=0.0.4", formatText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := statusFormat(tt.accept); got != tt.want {
+				t.Errorf("statusFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"desk", "desk"},
+		{`say "hi"`, `say \"hi\"`},
+		{`C:\camera`, `C:\\camera`},
+		{"two\nlines", `two\nlines`},
+		{`\"` + "\n", `\\\"\n`},
+	}
+
+	for _, tt := range tests {
+		if got := promEscape(tt.in); got != tt.want {
+			t.Errorf("promEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// networkConfig holds the settings for LAN device presence detection.
+type networkConfig struct {
+	devices  stringsFlag
+	interval time.Duration
+	timeout  time.Duration
+	weight   float64
+}
+
+func (c *networkConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Var(&c.devices, "net-device", "MAC or IP address (or hostname) of a LAN device which indicates presence; may be repeated")
+	fs.DurationVar(&c.interval, "net-interval", 30*time.Second, "how often to check for LAN devices")
+	fs.DurationVar(&c.timeout, "net-timeout", 5*time.Minute, "freshness window: how long a LAN device counts as present after it was last found")
+	fs.Float64Var(&c.weight, "net-weight", 1, "weight of LAN device presence in the fusion policy")
+}
+
+func (c networkConfig) enabled() bool {
+	return len(c.devices) > 0
+}
+
+func (c networkConfig) validate() error {
+	if c.interval <= 0 {
+		return fmt.Errorf("invalid -net-interval %v: must be positive", c.interval)
+	}
+
+	return nil
+}
+
+// networkScanner checks for configured devices on the LAN, by pinging IP
+// addresses and looking for MAC addresses in the ARP table. Phones often
+// ignore pings while asleep, so an IP address is also considered found when
+// it has a complete ARP entry.
+type networkScanner struct {
+	cfg   networkConfig
+	hosts []string
+	macs  map[string]bool
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+func newNetworkScanner(cfg networkConfig) *networkScanner {
+	s := &networkScanner{cfg: cfg, macs: map[string]bool{}}
+	for _, d := range cfg.devices {
+		if mac, err := net.ParseMAC(d); err == nil {
+			s.macs[mac.String()] = true
+		} else {
+			s.hosts = append(s.hosts, d)
+		}
+	}
+
+	return s
+}
+
+// watch checks for the devices every interval, until the context is
+// cancelled.
+func (s *networkScanner) watch(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		found, err := s.check(ctx)
+		if err != nil {
+			slog.Warn("checking for LAN devices", "err", err)
+		}
+
+		if found {
+			s.mu.Lock()
+			s.lastSeen = time.Now()
+			s.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *networkScanner) check(ctx context.Context) (bool, error) {
+	found := false
+
+	ips := map[string]bool{}
+	for _, h := range s.hosts {
+		if ping(ctx, h) {
+			found = true
+		}
+
+		addrs, err := net.DefaultResolver.LookupHost(ctx, h)
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ips[a] = true
+		}
+	}
+
+	if found {
+		return true, nil
+	}
+
+	arp, err := readARPTable(ctx)
+	if err != nil {
+		return false, fmt.Errorf("reading ARP table: %w", err)
+	}
+
+	for ip, mac := range arp {
+		if ips[ip] || s.macs[mac] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *networkScanner) lastActive() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastSeen
+}
+
+// ping sends a single ICMP echo request with the system ping command, which
+// avoids needing raw socket privileges.
+func ping(ctx context.Context, host string) bool {
+	// the timeout flag differs between platforms
+	timeoutFlag := "-W"
+	if runtime.GOOS == "darwin" {
+		timeoutFlag = "-t"
+	}
+
+	return exec.CommandContext(ctx, "ping", "-c", "1", timeoutFlag, "1", host).Run() == nil
+}
+
+// readARPTable returns the complete entries in the ARP table, as a map of IP
+// address to normalized MAC address.
+func readARPTable(ctx context.Context) (map[string]string, error) {
+	if runtime.GOOS == "linux" {
+		b, err := os.ReadFile("/proc/net/arp")
+		if err != nil {
+			return nil, err
+		}
+
+		return parseProcARP(b), nil
+	}
+
+	out, err := exec.CommandContext(ctx, "arp", "-an").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseARPCommand(out), nil
+}
+
+// parseProcARP parses Linux's /proc/net/arp, which looks like:
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	192.168.1.23     0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+func parseProcARP(b []byte) map[string]string {
+	entries := map[string]string{}
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Scan() // skip the header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// flag 0x2 means the entry is complete
+		if len(fields) < 4 || fields[2] != "0x2" {
+			continue
+		}
+
+		if mac, err := net.ParseMAC(fields[3]); err == nil {
+			entries[fields[0]] = mac.String()
+		}
+	}
+
+	return entries
+}
+
+// parseARPCommand parses the output of `arp -an` on macOS and the BSDs, which
+// looks like:
+//
+//	? (192.168.1.23) at aa:bb:cc:d:e:f on en0 ifscope [ethernet]
+func parseARPCommand(b []byte) map[string]string {
+	entries := map[string]string{}
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 || fields[2] != "at" {
+			continue
+		}
+
+		ip := strings.Trim(fields[1], "()")
+
+		if mac := normalizeMAC(fields[3]); mac != "" {
+			entries[ip] = mac
+		}
+	}
+
+	return entries
+}
+
+// normalizeMAC converts MAC addresses with elided leading zeroes (as printed
+// by BSD arp) into the canonical form. Incomplete entries return "".
+func normalizeMAC(s string) string {
+	parts := strings.Split(s, ":")
+	if len(parts) != 6 {
+		return ""
+	}
+
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+
+	mac, err := net.ParseMAC(strings.Join(parts, ":"))
+	if err != nil {
+		return ""
+	}
+
+	return mac.String()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openAPISpec documents the HTTP API. The router is built from it, so it
+// can't drift from the handlers.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// apiHandlers maps the spec's operationIds to their handlers.
+var apiHandlers = map[string]http.HandlerFunc{
+	"getSnapshot":     audited("access", limited(handleRequest)),
+	"captureSnapshot": audited("access", limited(handleRequest)),
+	"getSnapshotMeta": audited("access", handleSnapshotMeta),
+	"getStatus":       handleStatus,
+	"getSignals":      handleSignals,
+	"getZones":        handleZones,
+	"streamEvents":    handleEvents,
+	"pause":           audited("config", handlePause),
+	"resume":          audited("config", handleResume),
+	"getOpenAPI":      handleOpenAPI,
+	"webrtcViewer":    handleWebRTCViewer,
+	"webrtcOffer":     audited("access", limited(handleWebRTCOffer)),
+	"getHLS":          audited("access", handleHLS),
+	"getMJPEGStream":  audited("access", handleMJPEGStream),
+	"healthz":         handleHealthz,
+	"readyz":          handleReadyz,
+	"getDetector":     handleGetDetector,
+	"setDetector":     audited("config", handlePutDetector),
+	"getDetection":    handleGetDetection,
+	"setDetection":    audited("config", handlePutDetection),
+	"getDashboard":    handleDashboard,
+	"getBadgeSVG":     handleBadgeSVG,
+	"getBadgePNG":     handleBadgePNG,
+	"getCalendar":     handleCalendar,
+}
+
+func handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPISpec)
+}
+
+// newAPIMux routes each operation in the spec to its handler, by
+// operationId. Operations with security requirements are authorized with
+// the API key scopes they list. It's an error for an operation to have no
+// handler, or for a handler to have no operation.
+func newAPIMux(spec []byte, handlers map[string]http.HandlerFunc) (*http.ServeMux, error) {
+	doc := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI spec: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	used := map[string]bool{}
+
+	for path, item := range doc.Paths {
+		for method, raw := range item {
+			// path items can also hold parameters, summaries, etc.
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			default:
+				continue
+			}
+
+			op := struct {
+				OperationID string                `json:"operationId"`
+				Security    []map[string][]string `json:"security"`
+			}{}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("parsing OpenAPI operation %s %s: %w", method, path, err)
+			}
+
+			h, ok := handlers[op.OperationID]
+			if !ok {
+				return nil, fmt.Errorf("no handler for OpenAPI operation %q (%s %s)", op.OperationID, strings.ToUpper(method), path)
+			}
+			used[op.OperationID] = true
+
+			scopes := []string{}
+			for _, req := range op.Security {
+				scopes = append(scopes, req["apiKey"]...)
+			}
+
+			if len(scopes) > 0 {
+				h = authorized(scopes, h)
+			}
+
+			// the root path must match exactly, not act as a catch-all
+			pattern := path
+			if strings.HasSuffix(pattern, "/") {
+				pattern += "{$}"
+			}
+
+			mux.HandleFunc(strings.ToUpper(method)+" "+pattern, h)
+		}
+	}
+
+	unused := []string{}
+	for id := range handlers {
+		if !used[id] {
+			unused = append(unused, id)
+		}
+	}
+
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return nil, fmt.Errorf("handlers not in the OpenAPI spec: %s", strings.Join(unused, ", "))
+	}
+
+	return mux, nil
+}
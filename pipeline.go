@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// The frame pipeline's stages. Frames are captured, then detected (converted
+// to grayscale, classified, and annotated), then encoded. Snapshot requests
+// and the background detection loop run the stages in turn, while streams run
+// them concurrently, connected by pipelineQueues.
+var (
+	captureStage = &pipelineStage{name: "capture"}
+	detectStage  = &pipelineStage{name: "detect"}
+	encodeStage  = &pipelineStage{name: "encode"}
+
+	pipelineStages = []*pipelineStage{captureStage, detectStage, encodeStage}
+)
+
+// pipelineStage counts the frames through a stage of the frame pipeline, and
+// the time spent on them.
+type pipelineStage struct {
+	name string
+
+	frames atomic.Uint64
+	// dropped counts frames dropped from the stage's queue, because the
+	// stage hadn't taken them before newer frames arrived
+	dropped atomic.Uint64
+	// busy is the total time spent on frames
+	busy atomic.Int64
+}
+
+// timed counts a frame which the stage started on at start.
+func (s *pipelineStage) timed(start time.Time) {
+	s.frames.Add(1)
+	s.busy.Add(int64(time.Since(start)))
+}
+
+// pipelineFrame is a frame passing through the stream pipeline.
+type pipelineFrame struct {
+	img gocv.Mat
+	at  time.Time
+	gen uint64
+}
+
+// pipelineQueue connects two stages of the stream pipeline. It holds a single
+// frame, and when the next stage hasn't taken it by the time a newer frame
+// arrives, the older frame is dropped, so a slow stage never holds up the ones
+// before it, and frames never pile up.
+type pipelineQueue struct {
+	ch   chan *pipelineFrame
+	next *pipelineStage
+}
+
+func newPipelineQueue(next *pipelineStage) *pipelineQueue {
+	return &pipelineQueue{ch: make(chan *pipelineFrame, 1), next: next}
+}
+
+// push queues the frame, dropping the oldest queued frame if full. Each queue
+// must only have one sender.
+func (q *pipelineQueue) push(f *pipelineFrame) {
+	for {
+		select {
+		case q.ch <- f:
+			return
+		default:
+		}
+
+		select {
+		case old := <-q.ch:
+			old.img.Close()
+			q.next.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// drain closes any frame left in the queue.
+func (q *pipelineQueue) drain() {
+	for {
+		select {
+		case f := <-q.ch:
+			f.img.Close()
+		default:
+			return
+		}
+	}
+}
+
+// runPipeline captures frames at the hub's interval, and passes them through
+// the detect and encode stages, each in its own goroutine, until the context
+// is cancelled. Encoded frames are published to the hub's subscribers.
+func (h *frameHub) runPipeline(ctx context.Context) {
+	detectQ, encodeQ := newPipelineQueue(detectStage), newPipelineQueue(encodeStage)
+
+	done := make(chan struct{}, 2)
+	defer func() {
+		<-done
+		<-done
+		detectQ.drain()
+		encodeQ.drain()
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		h.detectFrames(ctx, detectQ, encodeQ)
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		h.encodeFrames(ctx, encodeQ)
+	}()
+
+	h.captureFrames(ctx, detectQ)
+}
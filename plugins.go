@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pluginNotifier runs every executable in a directory for each event, passing
+// the event as JSON on stdin. This lets outputs be written in any language.
+type pluginNotifier struct {
+	dir string
+}
+
+func newPluginNotifier(dir string) (*pluginNotifier, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("plugins directory: %w", err)
+	}
+
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("plugins directory %q is not a directory", dir)
+	}
+
+	return &pluginNotifier{dir: dir}, nil
+}
+
+// plugins lists the executables in the plugins directory. The directory is
+// re-read for every event so plugins can be added or removed without a
+// restart.
+func (n *pluginNotifier) plugins() ([]string, error) {
+	entries, err := os.ReadDir(n.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plugins := []string{}
+	for _, e := range entries {
+		// skip hidden files (editor swap files, etc.)
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		fi, err := os.Stat(filepath.Join(n.dir, e.Name()))
+		if err != nil || !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		plugins = append(plugins, filepath.Join(n.dir, e.Name()))
+	}
+
+	sort.Strings(plugins)
+
+	return plugins, nil
+}
+
+func (n *pluginNotifier) notify(ctx context.Context, ev event) error {
+	plugins, err := n.plugins()
+	if err != nil {
+		return fmt.Errorf("listing plugins: %w", err)
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshalling event: %w", err)
+	}
+
+	errs := []error{}
+	for _, p := range plugins {
+		if err := runPlugin(ctx, p, ev, payload); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", filepath.Base(p), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func runPlugin(ctx context.Context, path string, ev event, payload []byte) error {
+	out := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = append(os.Environ(),
+		"PRESENCE_EVENT="+string(ev.Type),
+		"PRESENCE_STATE="+ev.State,
+		"PRESENCE_ZONE="+ev.Zone,
+	)
+
+	err := cmd.Run()
+	if out.Len() > 0 {
+		slog.Debug("plugin output", "plugin", filepath.Base(path), "output", out.String())
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out.Bytes()))
+	}
+
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// postgresSampleInterval is how often the presence state is sampled for
+	// the periodic aggregates
+	postgresSampleInterval = time.Second
+	// postgresMaintenanceInterval is how often raw data is rolled up into
+	// hourly and daily aggregates, and pruned
+	postgresMaintenanceInterval = time.Hour
+)
+
+// postgresConfig holds the settings for the PostgreSQL event sink.
+type postgresConfig struct {
+	dsn       string
+	events    string
+	interval  time.Duration
+	timescale bool
+	retention time.Duration
+}
+
+func (c *postgresConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.dsn, "postgres-dsn", "", "PostgreSQL connection string - enables the SQL sink (default $PRESENCE_POSTGRES_DSN)")
+	fs.StringVar(&c.events, "postgres-events", "arrival,departure", "comma-separated events to record in PostgreSQL")
+	fs.DurationVar(&c.interval, "postgres-interval", time.Minute, "period of the occupancy aggregates recorded in PostgreSQL (0 to disable)")
+	fs.BoolVar(&c.timescale, "postgres-timescale", false, "create the tables as TimescaleDB hypertables")
+	fs.DurationVar(&c.retention, "postgres-retention", 30*24*time.Hour, "how long to keep raw events and occupancy in PostgreSQL, once rolled up into hourly and daily aggregates (0 to keep forever)")
+}
+
+func (c postgresConfig) enabled() bool {
+	return c.dsn != "" || os.Getenv("PRESENCE_POSTGRES_DSN") != ""
+}
+
+// postgresSchema creates the tables, if they don't already exist.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS presence_events (
+	time      timestamptz NOT NULL,
+	type      text        NOT NULL,
+	state     text        NOT NULL,
+	last_seen timestamptz,
+	faces     integer     NOT NULL,
+	camera    text        NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS presence_occupancy (
+	time      timestamptz      NOT NULL,
+	period    interval         NOT NULL,
+	camera    text             NOT NULL,
+	occupancy double precision NOT NULL,
+	max_faces integer          NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS presence_expressions (
+	time       timestamptz      NOT NULL,
+	period     interval         NOT NULL,
+	camera     text             NOT NULL,
+	expression text             NOT NULL,
+	share      double precision NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS presence_hourly (
+	time       timestamptz      NOT NULL,
+	camera     text             NOT NULL,
+	occupancy  double precision,
+	max_faces  integer          NOT NULL,
+	arrivals   integer          NOT NULL,
+	departures integer          NOT NULL,
+	PRIMARY KEY (time, camera)
+);
+
+CREATE TABLE IF NOT EXISTS presence_daily (
+	time       timestamptz      NOT NULL,
+	camera     text             NOT NULL,
+	occupancy  double precision,
+	max_faces  integer          NOT NULL,
+	arrivals   integer          NOT NULL,
+	departures integer          NOT NULL,
+	PRIMARY KEY (time, camera)
+);
+
+CREATE TABLE IF NOT EXISTS presence_expressions_hourly (
+	time       timestamptz      NOT NULL,
+	camera     text             NOT NULL,
+	expression text             NOT NULL,
+	share      double precision,
+	PRIMARY KEY (time, camera, expression)
+);
+`
+
+const timescaleSchema = `
+SELECT create_hypertable('presence_events', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('presence_occupancy', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('presence_hourly', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('presence_daily', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('presence_expressions', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('presence_expressions_hourly', 'time', if_not_exists => TRUE);
+`
+
+// postgresHourlyRollup (re)computes the hourly aggregates for the last couple
+// of hours, so that the current hour is completed by the following run. The
+// occupancy is weighted by the length of each period.
+const postgresHourlyRollup = `
+WITH occ AS (
+	SELECT date_trunc('hour', time) AS bucket, camera,
+		sum(occupancy * extract(epoch FROM period)) / nullif(sum(extract(epoch FROM period)), 0) AS occupancy,
+		max(max_faces) AS max_faces
+	FROM presence_occupancy
+	WHERE time >= date_trunc('hour', now()) - interval '2 hours'
+	GROUP BY 1, 2
+), ev AS (
+	SELECT date_trunc('hour', time) AS bucket, camera,
+		count(*) FILTER (WHERE type = 'arrival') AS arrivals,
+		count(*) FILTER (WHERE type = 'departure') AS departures
+	FROM presence_events
+	WHERE time >= date_trunc('hour', now()) - interval '2 hours'
+	GROUP BY 1, 2
+)
+INSERT INTO presence_hourly (time, camera, occupancy, max_faces, arrivals, departures)
+SELECT coalesce(occ.bucket, ev.bucket), coalesce(occ.camera, ev.camera), occ.occupancy,
+	coalesce(occ.max_faces, 0), coalesce(ev.arrivals, 0), coalesce(ev.departures, 0)
+FROM occ FULL OUTER JOIN ev ON occ.bucket = ev.bucket AND occ.camera = ev.camera
+ON CONFLICT (time, camera) DO UPDATE SET
+	occupancy = EXCLUDED.occupancy, max_faces = EXCLUDED.max_faces,
+	arrivals = EXCLUDED.arrivals, departures = EXCLUDED.departures
+`
+
+// postgresExpressionsRollup (re)computes the hourly expression aggregates for
+// the last couple of hours: the share of the sampled time in which each
+// expression was seen.
+const postgresExpressionsRollup = `
+WITH total AS (
+	SELECT date_trunc('hour', time) AS bucket, camera, sum(extract(epoch FROM period)) AS seconds
+	FROM presence_occupancy
+	WHERE time >= date_trunc('hour', now()) - interval '2 hours'
+	GROUP BY 1, 2
+), expr AS (
+	SELECT date_trunc('hour', time) AS bucket, camera, expression,
+		sum(share * extract(epoch FROM period)) AS seconds
+	FROM presence_expressions
+	WHERE time >= date_trunc('hour', now()) - interval '2 hours'
+	GROUP BY 1, 2, 3
+)
+INSERT INTO presence_expressions_hourly (time, camera, expression, share)
+SELECT expr.bucket, expr.camera, expr.expression, expr.seconds / nullif(total.seconds, 0)
+FROM expr JOIN total ON expr.bucket = total.bucket AND expr.camera = total.camera
+ON CONFLICT (time, camera, expression) DO UPDATE SET share = EXCLUDED.share
+`
+
+// postgresDailyRollup (re)computes the daily aggregates for today and
+// yesterday from the hourly aggregates.
+const postgresDailyRollup = `
+INSERT INTO presence_daily (time, camera, occupancy, max_faces, arrivals, departures)
+SELECT date_trunc('day', time), camera, avg(occupancy), max(max_faces), sum(arrivals), sum(departures)
+FROM presence_hourly
+WHERE time >= date_trunc('day', now()) - interval '1 day'
+GROUP BY 1, 2
+ON CONFLICT (time, camera) DO UPDATE SET
+	occupancy = EXCLUDED.occupancy, max_faces = EXCLUDED.max_faces,
+	arrivals = EXCLUDED.arrivals, departures = EXCLUDED.departures
+`
+
+// postgresSink records events, and periodic occupancy aggregates, in a
+// PostgreSQL (or TimescaleDB) database for long-term analytics.
+type postgresSink struct {
+	cfg     postgresConfig
+	db      *pgxpool.Pool
+	events  map[eventType]bool
+	tracker *presenceTracker
+}
+
+func newPostgresSink(ctx context.Context, cfg postgresConfig, tracker *presenceTracker) (*postgresSink, error) {
+	events, err := parseEventTypes(cfg.events)
+	if err != nil {
+		return nil, err
+	}
+
+	// raw data must outlive the window the rollups are computed from
+	if cfg.retention != 0 && cfg.retention < 24*time.Hour {
+		return nil, fmt.Errorf("invalid PostgreSQL retention %v: must be at least 24h", cfg.retention)
+	}
+
+	if cfg.dsn == "" {
+		cfg.dsn = os.Getenv("PRESENCE_POSTGRES_DSN")
+	}
+
+	db, err := pgxpool.New(ctx, cfg.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to PostgreSQL: %w", err)
+	}
+
+	if _, err := db.Exec(ctx, postgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating tables: %w", err)
+	}
+
+	if cfg.timescale {
+		if _, err := db.Exec(ctx, timescaleSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating hypertables: %w", err)
+		}
+	}
+
+	return &postgresSink{cfg: cfg, db: db, events: events, tracker: tracker}, nil
+}
+
+func (s *postgresSink) close() {
+	s.db.Close()
+}
+
+func (s *postgresSink) notify(ctx context.Context, ev event) error {
+	if !s.events[ev.Type] {
+		return nil
+	}
+
+	var lastSeen *time.Time
+	if !ev.LastSeen.IsZero() {
+		lastSeen = &ev.LastSeen
+	}
+
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO presence_events (time, type, state, last_seen, faces, camera) VALUES ($1, $2, $3, $4, $5, $6)`,
+		ev.Time, string(ev.Type), ev.State, lastSeen, ev.Faces, ev.Camera)
+	if err != nil {
+		return fmt.Errorf("recording event: %w", err)
+	}
+
+	return nil
+}
+
+// watch samples the presence state, and records the share of each period
+// during which presence was detected, until the context is cancelled.
+func (s *postgresSink) watch(ctx context.Context) {
+	if s.cfg.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(postgresSampleInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	samples, present, maxFaces := 0, 0, 0
+	// expressions counts the samples in which each expression was seen
+	expressions := map[string]int{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			st := s.tracker.status()
+			if !st.Known {
+				continue
+			}
+
+			samples++
+			if st.Present {
+				present++
+			}
+			maxFaces = max(maxFaces, st.Faces)
+
+			seen := map[string]bool{}
+			for _, d := range s.tracker.lastDetections() {
+				if d.Expression != "" && !seen[d.Expression] {
+					seen[d.Expression] = true
+					expressions[d.Expression]++
+				}
+			}
+
+			if now.Sub(start) < s.cfg.interval {
+				continue
+			}
+
+			occupancy := float64(present) / float64(samples)
+			if err := s.record(ctx, start, now.Sub(start), st.Camera, occupancy, maxFaces); err != nil {
+				slog.Warn("recording occupancy in PostgreSQL", "err", err)
+			}
+
+			for expr, n := range expressions {
+				share := float64(n) / float64(samples)
+				if err := s.recordExpression(ctx, start, now.Sub(start), st.Camera, expr, share); err != nil {
+					slog.Warn("recording expressions in PostgreSQL", "err", err)
+				}
+			}
+
+			start = now
+			samples, present, maxFaces = 0, 0, 0
+			clear(expressions)
+		}
+	}
+}
+
+func (s *postgresSink) record(ctx context.Context, start time.Time, period time.Duration, camera string, occupancy float64, maxFaces int) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO presence_occupancy (time, period, camera, occupancy, max_faces) VALUES ($1, $2, $3, $4, $5)`,
+		start, pgtype.Interval{Microseconds: period.Microseconds(), Valid: true}, camera, occupancy, maxFaces)
+
+	return err
+}
+
+func (s *postgresSink) recordExpression(ctx context.Context, start time.Time, period time.Duration, camera, expression string, share float64) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO presence_expressions (time, period, camera, expression, share) VALUES ($1, $2, $3, $4, $5)`,
+		start, pgtype.Interval{Microseconds: period.Microseconds(), Valid: true}, camera, expression, share)
+
+	return err
+}
+
+// maintain rolls raw data up into hourly and daily aggregates, and prunes raw
+// data older than the retention period, until the context is cancelled.
+func (s *postgresSink) maintain(ctx context.Context) {
+	ticker := time.NewTicker(postgresMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.rollup(ctx); err != nil {
+			slog.Warn("PostgreSQL maintenance failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *postgresSink) rollup(ctx context.Context) error {
+	if _, err := s.db.Exec(ctx, postgresHourlyRollup); err != nil {
+		return fmt.Errorf("rolling up hourly aggregates: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, postgresExpressionsRollup); err != nil {
+		return fmt.Errorf("rolling up hourly expression aggregates: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, postgresDailyRollup); err != nil {
+		return fmt.Errorf("rolling up daily aggregates: %w", err)
+	}
+
+	if s.cfg.retention == 0 {
+		return nil
+	}
+
+	age := pgtype.Interval{Microseconds: s.cfg.retention.Microseconds(), Valid: true}
+	for _, table := range []string{"presence_events", "presence_occupancy", "presence_expressions"} {
+		tag, err := s.db.Exec(ctx, "DELETE FROM "+table+" WHERE time < now() - $1::interval", age)
+		if err != nil {
+			return fmt.Errorf("pruning %s: %w", table, err)
+		}
+
+		if n := tag.RowsAffected(); n > 0 {
+			slog.Debug("Pruned old rows", "table", table, "rows", n)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type eventType string
+
+const (
+	eventArrival   eventType = "arrival"
+	eventDeparture eventType = "departure"
+)
+
+// parseEventTypes parses a comma-separated list of event types, as used to
+// select which events an output should be sent.
+func parseEventTypes(s string) (map[eventType]bool, error) {
+	types := map[eventType]bool{}
+	for _, e := range strings.Split(s, ",") {
+		switch t := eventType(strings.TrimSpace(e)); t {
+		case eventArrival, eventDeparture:
+			types[t] = true
+		default:
+			return nil, fmt.Errorf("unsupported event type %q", e)
+		}
+	}
+
+	return types, nil
+}
+
+// event describes a change in presence state.
+type event struct {
+	Type eventType `json:"type"`
+	// State is the new presence state, "present" or "away"
+	State    string    `json:"state"`
+	Time     time.Time `json:"time"`
+	LastSeen time.Time `json:"lastSeen"`
+	Faces    int       `json:"faces"`
+	Camera   string    `json:"camera"`
+	// Zone is the -zone the event relates to, or empty for the whole frame
+	Zone string `json:"zone,omitempty"`
+	// Detections are the faces in the frame which triggered the event
+	Detections []faceDetection `json:"detections,omitempty"`
+	// Snapshot is the annotated JPEG frame which triggered the event
+	Snapshot []byte `json:"-"`
+}
+
+// faceDetection is a face found in a frame.
+type faceDetection struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Classifier is the name of the classifier which found the face
+	Classifier string `json:"classifier,omitempty"`
+	// Masked is whether the face is covered, when -mask-model is set
+	Masked          *bool   `json:"masked,omitempty"`
+	MaskProbability float64 `json:"maskProbability,omitempty"`
+	// Expression is the face's expression, like "smiling", when
+	// -expression-model is set
+	Expression            string  `json:"expression,omitempty"`
+	ExpressionProbability float64 `json:"expressionProbability,omitempty"`
+}
+
+func newFaceDetection(r image.Rectangle, classifier string) faceDetection {
+	return faceDetection{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Classifier: classifier}
+}
+
+// scaled returns the detection in a frame scaled by f.
+func (d faceDetection) scaled(f float64) faceDetection {
+	d.X = int(float64(d.X) * f)
+	d.Y = int(float64(d.Y) * f)
+	d.Width = int(float64(d.Width) * f)
+	d.Height = int(float64(d.Height) * f)
+
+	return d
+}
+
+// notifier is implemented by anything that wants to be told about presence
+// events.
+type notifier interface {
+	notify(ctx context.Context, ev event) error
+}
+
+// stoppableNotifier is a notifier which sends events later, like once they've
+// settled, and must be stopped before the outputs behind it are closed.
+type stoppableNotifier interface {
+	notifier
+	stop()
+}
+
+// presenceTracker turns a stream of per-frame face counts into arrival and
+// departure events.
+type presenceTracker struct {
+	mu        sync.Mutex
+	notifiers []*notifyQueue
+	// sending counts the notifiers' queues which are being sent
+	sending sync.WaitGroup
+	// zones track presence in regions of the frame, with their own notifiers
+	zones         []*zone
+	zoneNotifiers []*notifyQueue
+	// fusion decides whether presence is seen, from the camera and any other
+	// signals
+	fusion      *fusionEngine
+	cam         *cameraSignal
+	camera      string
+	awayTimeout *awayTimeouts
+	lastSeen    time.Time
+	// since is when the current state began
+	since      time.Time
+	faces      int
+	detections []faceDetection
+	// known is false until the first frame has been observed, so that the
+	// initial state doesn't trigger an event
+	known   bool
+	present bool
+	// closed is set on shutdown, after which no events are dispatched
+	closed bool
+}
+
+// presenceStatus is a point-in-time view of the tracker's state.
+type presenceStatus struct {
+	Known    bool      `json:"known"`
+	Present  bool      `json:"present"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"lastSeen"`
+	Faces    int       `json:"faces"`
+	Camera   string    `json:"camera"`
+}
+
+// State returns the presence state as a string, "present", "away", or
+// "unknown" before anything has been observed.
+func (s presenceStatus) State() string {
+	switch {
+	case !s.Known:
+		return "unknown"
+	case s.Present:
+		return "present"
+	default:
+		return "away"
+	}
+}
+
+// newPresenceTracker creates a tracker whose fusion engine has the camera as
+// its first signal, with the given weight and freshness window.
+func newPresenceTracker(camera string, awayTimeout *awayTimeouts, cameraWeight float64, cameraFreshness time.Duration, threshold float64) *presenceTracker {
+	t := &presenceTracker{
+		camera:      camera,
+		awayTimeout: awayTimeout,
+		fusion:      newFusionEngine(threshold),
+		cam:         &cameraSignal{},
+	}
+
+	t.fusion.addSignal("camera", t.cam, cameraWeight, cameraFreshness)
+
+	return t
+}
+
+func (t *presenceTracker) status() presenceStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return presenceStatus{
+		Known:    t.known,
+		Present:  t.present,
+		Since:    t.since,
+		LastSeen: t.lastSeen,
+		Faces:    t.faces,
+		Camera:   t.camera,
+	}
+}
+
+// wait waits for notifications in progress to be sent.
+func (t *presenceTracker) wait() {
+	t.sending.Wait()
+}
+
+// close stops dispatching events, stops notifiers which send them later, and
+// waits for notifications in progress, so that the outputs can be closed.
+func (t *presenceTracker) close() {
+	t.mu.Lock()
+	t.closed = true
+	notifiers := append(slices.Clone(t.notifiers), t.zoneNotifiers...)
+	t.mu.Unlock()
+
+	t.sending.Wait()
+
+	for _, q := range notifiers {
+		if s, ok := q.n.(stoppableNotifier); ok {
+			s.stop()
+		}
+	}
+}
+
+// lastDetections returns the faces in the most recent frame.
+func (t *presenceTracker) lastDetections() []faceDetection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.detections
+}
+
+// addSignal adds a signal which is fused with the camera.
+func (t *presenceTracker) addSignal(name string, s signal, weight float64, freshness time.Duration) {
+	t.fusion.addSignal(name, s, weight, freshness)
+}
+
+func (t *presenceTracker) addNotifier(n notifier) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.notifiers = append(t.notifiers, &notifyQueue{n: n})
+}
+
+// setAwayTimeout changes the away timeout outside of any -away-timeout-at
+// windows.
+func (t *presenceTracker) setAwayTimeout(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at := *t.awayTimeout
+	at.fallback = d
+	t.awayTimeout = &at
+}
+
+// awayTimeoutFallback returns the away timeout outside of any
+// -away-timeout-at windows.
+func (t *presenceTracker) awayTimeoutFallback() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.awayTimeout.fallback
+}
+
+// setZones sets the zones to track presence in separately.
+func (t *presenceTracker) setZones(zones []*zone) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.zones = zones
+}
+
+// addZoneNotifier adds a notifier for zones' events. Zone events aren't sent
+// to the other notifiers, as they're about the whole frame.
+func (t *presenceTracker) addZoneNotifier(n notifier) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.zoneNotifiers = append(t.zoneNotifiers, &notifyQueue{n: n})
+}
+
+// zoneStatuses returns each zone's presence state.
+func (t *presenceTracker) zoneStatuses() []zoneStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make([]zoneStatus, len(t.zones))
+	for i, z := range t.zones {
+		statuses[i] = z.status()
+	}
+
+	return statuses
+}
+
+// observe records the faces seen at the given time, in a frame of the given
+// size. The snapshot function is only called when the observation causes an
+// event.
+func (t *presenceTracker) observe(now time.Time, frame image.Point, detections []faceDetection, snapshot func() ([]byte, error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.observeZones(now, frame, detections, snapshot)
+
+	t.faces = len(detections)
+	t.detections = detections
+	t.cam.observe(now, t.faces)
+
+	t.update(now, snapshot)
+}
+
+// evaluate decides presence from the signals other than the camera, for when
+// there's no local camera to observe frames from.
+func (t *presenceTracker) evaluate(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.update(now, noSnapshot)
+}
+
+// pause ends any presence when capture is paused, as a departure at when it
+// was last seen, since whether it continues isn't known until capture
+// resumes.
+func (t *presenceTracker) pause(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.known || !t.present {
+		return
+	}
+
+	t.present = false
+	t.since = now
+	t.faces = 0
+	t.detections = nil
+
+	t.dispatch(event{
+		Type:     eventDeparture,
+		State:    "away",
+		Time:     now,
+		LastSeen: t.lastSeen,
+		Camera:   t.camera,
+	}, t.notifiers, noSnapshot)
+}
+
+// noSnapshot is the snapshot function for events without a frame.
+func noSnapshot() ([]byte, error) {
+	return nil, nil
+}
+
+// update fuses the signals at the given time, and dispatches an event if the
+// presence state changed. Callers must hold t.mu.
+func (t *presenceTracker) update(now time.Time, snapshot func() ([]byte, error)) {
+	seen := t.fusion.present(now)
+	if seen {
+		t.lastSeen = now
+	}
+
+	// presence lasts the away timeout after it was last seen - but only
+	// when it hasn't already ended, like when paused
+	present := seen || (t.present && now.Sub(t.lastSeen) < t.awayTimeout.at(now))
+	if !t.known {
+		t.known = true
+		t.present = present
+		t.since = now
+		return
+	}
+
+	if present == t.present {
+		return
+	}
+
+	t.present = present
+	t.since = now
+
+	ev := event{
+		Type:       eventDeparture,
+		State:      "away",
+		Time:       now,
+		LastSeen:   t.lastSeen,
+		Faces:      t.faces,
+		Camera:     t.camera,
+		Detections: t.detections,
+	}
+	if present {
+		ev.Type = eventArrival
+		ev.State = "present"
+	}
+
+	t.dispatch(ev, t.notifiers, snapshot)
+}
+
+// observeZones feeds each zone the detections within it.
+func (t *presenceTracker) observeZones(now time.Time, frame image.Point, detections []faceDetection, snapshot func() ([]byte, error)) {
+	for _, z := range t.zones {
+		in := []faceDetection{}
+		for _, d := range detections {
+			if z.contains(frame, d) {
+				in = append(in, d)
+			}
+		}
+
+		if ev := z.observe(now, t.awayTimeout.at(now), in, t.camera); ev != nil {
+			t.dispatch(*ev, t.zoneNotifiers, snapshot)
+		}
+	}
+}
+
+// dispatch sends the event, with a snapshot, to the notifiers.
+func (t *presenceTracker) dispatch(ev event, notifiers []*notifyQueue, snapshot func() ([]byte, error)) {
+	if len(notifiers) == 0 || t.closed {
+		return
+	}
+
+	img, err := snapshot()
+	if err != nil {
+		slog.Warn("couldn't encode event snapshot", "event", ev.Type, "err", err)
+	}
+	ev.Snapshot = img
+
+	for _, q := range notifiers {
+		q.push(ev, &t.sending)
+	}
+}
+
+// notifyQueue sends events to a notifier one at a time, in the order they
+// were dispatched, so outputs which keep state (like the history, or a GPIO
+// pin) end up in the latest one. A slow notifier doesn't hold up the others.
+type notifyQueue struct {
+	n notifier
+
+	mu     sync.Mutex
+	events []event
+	// running is set while a goroutine is sending the queued events
+	running bool
+}
+
+// push queues the event, starting a goroutine to send it unless one's already
+// running. The goroutine is counted in sending until the queue is empty.
+func (q *notifyQueue) push(ev event, sending *sync.WaitGroup) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.events = append(q.events, ev)
+	if q.running {
+		return
+	}
+
+	q.running = true
+	sending.Add(1)
+	go q.run(sending)
+}
+
+func (q *notifyQueue) run(sending *sync.WaitGroup) {
+	defer sending.Done()
+
+	for {
+		q.mu.Lock()
+		if len(q.events) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		ev := q.events[0]
+		q.events = q.events[1:]
+		q.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := q.n.notify(ctx, ev); err != nil {
+			slog.Error("sending notification", "event", ev.Type, "err", err)
+		}
+		cancel()
+	}
+}
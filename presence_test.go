@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"image"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier records the events it's sent.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (n *recordingNotifier) notify(_ context.Context, ev event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.events = append(n.events, ev)
+
+	return nil
+}
+
+// take returns the events sent since it was last called.
+func (n *recordingNotifier) take() []event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	evs := n.events
+	n.events = nil
+
+	return evs
+}
+
+// mutableSignal is a signal whose last activity can be set.
+type mutableSignal struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (s *mutableSignal) set(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = t
+}
+
+func (s *mutableSignal) lastActive() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.last
+}
+
+func TestPresenceTrackerEvaluate(t *testing.T) {
+	t0 := time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)
+
+	// no camera frames, only a remote instance
+	tracker := newPresenceTracker("", &awayTimeouts{fallback: time.Minute}, 1, time.Second, 1)
+	remote := &mutableSignal{}
+	tracker.addSignal("remote:hallway", remote, 1, 15*time.Second)
+	n := &recordingNotifier{}
+	tracker.addNotifier(n)
+
+	tracker.evaluate(t0)
+	if st := tracker.status(); !st.Known || st.Present {
+		t.Fatalf("initially %s, want away", st.State())
+	}
+
+	steps := []struct {
+		after  time.Duration
+		active bool
+		want   eventType
+	}{
+		{after: 5 * time.Second, active: true, want: eventArrival},
+		{after: 10 * time.Second},
+		// within the freshness window, and then the away timeout
+		{after: 30 * time.Second},
+		{after: 80 * time.Second, want: eventDeparture},
+		{after: 90 * time.Second},
+	}
+
+	for _, s := range steps {
+		now := t0.Add(s.after)
+		if s.active {
+			remote.set(now)
+		}
+
+		tracker.evaluate(now)
+		tracker.wait()
+
+		evs := n.take()
+		switch {
+		case s.want == "" && len(evs) != 0:
+			t.Errorf("after %v, sent %+v, want nothing", s.after, evs)
+		case s.want != "" && (len(evs) != 1 || evs[0].Type != s.want):
+			t.Errorf("after %v, sent %+v, want an %s", s.after, evs, s.want)
+		}
+	}
+}
+
+func TestPresenceTrackerPause(t *testing.T) {
+	t0 := time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)
+	face := []faceDetection{{X: 10, Y: 10, Width: 50, Height: 50}}
+	frame := image.Pt(640, 480)
+
+	tracker := newPresenceTracker("0", &awayTimeouts{fallback: time.Minute}, 1, time.Second, 1)
+	n := &recordingNotifier{}
+	tracker.addNotifier(n)
+
+	tracker.observe(t0, frame, face, noSnapshot)
+	tracker.observe(t0.Add(10*time.Second), frame, nil, noSnapshot)
+
+	tracker.pause(t0.Add(20 * time.Second))
+	tracker.wait()
+
+	evs := n.take()
+	if len(evs) != 1 || evs[0].Type != eventDeparture || !evs[0].LastSeen.Equal(t0) {
+		t.Fatalf("pausing while present sent %+v, want a departure last seen at %v", evs, t0)
+	}
+
+	if st := tracker.status(); st.Present {
+		t.Error("still present while paused")
+	}
+
+	// resuming within the away timeout, with nobody there, doesn't bring
+	// presence back
+	tracker.observe(t0.Add(30*time.Second), frame, nil, noSnapshot)
+	tracker.wait()
+
+	if evs := n.take(); len(evs) != 0 {
+		t.Errorf("resuming with nobody there sent %+v", evs)
+	}
+
+	tracker.observe(t0.Add(40*time.Second), frame, face, noSnapshot)
+	tracker.wait()
+
+	if evs := n.take(); len(evs) != 1 || evs[0].Type != eventArrival {
+		t.Errorf("resuming with someone there sent %+v, want an arrival", evs)
+	}
+
+	// pausing while away sends nothing
+	tracker.observe(t0.Add(2*time.Minute), frame, nil, noSnapshot)
+	tracker.wait()
+	n.take()
+	tracker.pause(t0.Add(3 * time.Minute))
+	tracker.wait()
+
+	if evs := n.take(); len(evs) != 0 {
+		t.Errorf("pausing while away sent %+v", evs)
+	}
+}
+
+// slowArrivalNotifier records events, taking longer to send arrivals.
+type slowArrivalNotifier struct {
+	recordingNotifier
+}
+
+func (n *slowArrivalNotifier) notify(ctx context.Context, ev event) error {
+	if ev.Type == eventArrival {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return n.recordingNotifier.notify(ctx, ev)
+}
+
+func TestPresenceTrackerDispatchOrder(t *testing.T) {
+	t0 := time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)
+	face := []faceDetection{{X: 10, Y: 10, Width: 50, Height: 50}}
+	frame := image.Pt(640, 480)
+
+	tracker := newPresenceTracker("0", &awayTimeouts{fallback: time.Second}, 1, time.Second, 1)
+	n := &slowArrivalNotifier{}
+	tracker.addNotifier(n)
+
+	tracker.observe(t0, frame, nil, noSnapshot)
+	for i := range 3 {
+		// an arrival, and a departure while it's still being sent
+		start := t0.Add(time.Duration(i+1) * time.Minute)
+		tracker.observe(start, frame, face, noSnapshot)
+		tracker.observe(start.Add(10*time.Second), frame, nil, noSnapshot)
+	}
+	tracker.wait()
+
+	evs := n.take()
+	if len(evs) != 6 {
+		t.Fatalf("sent %d events, want 6", len(evs))
+	}
+
+	for i, ev := range evs {
+		want := eventArrival
+		if i%2 == 1 {
+			want = eventDeparture
+		}
+
+		if ev.Type != want {
+			t.Errorf("event %d is %s, want %s", i, ev.Type, want)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package presencepb contains the gRPC API definition for presence, and the
+// code generated from it.
+package presencepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative presence.proto
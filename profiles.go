@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profilesInterval is how often the detection profile is re-evaluated
+const profilesInterval = 30 * time.Second
+
+// profiles switches detection profiles, when -detection-profiles is set. It's
+// nil otherwise.
+var profiles *profileSwitcher
+
+// profilesConfig holds the detection profile settings.
+type profilesConfig struct {
+	file      string
+	latitude  float64
+	longitude float64
+}
+
+func (c *profilesConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "detection-profiles", "", "JSON file of detection profiles to switch between by time of day or frame brightness")
+	fs.Float64Var(&c.latitude, "latitude", 0, "latitude, for sunrise and sunset in -detection-profiles")
+	fs.Float64Var(&c.longitude, "longitude", 0, "longitude (east is positive), for sunrise and sunset in -detection-profiles")
+}
+
+func (c profilesConfig) enabled() bool {
+	return c.file != ""
+}
+
+// detectionProfile is a set of detection parameters which applies at certain
+// times of day, or in certain light. Profiles are matched in order, and
+// parameters they leave out keep their base values - from flags and
+// -detection-config. For example:
+//
+//	[
+//	  {"name": "evening", "from": "sunset-30m", "to": "sunrise", "tuning": {"equalize": true, "minFace": 0.05}},
+//	  {"name": "dim", "maxBrightness": 70, "tuning": {"equalize": true}}
+//	]
+type detectionProfile struct {
+	Name string `json:"name"`
+	// From and To bound the time of day the profile applies, as "HH:MM",
+	// or "sunrise" or "sunset" with an optional offset, like "sunset-30m"
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// MinBrightness and MaxBrightness bound the measured frame brightness
+	// (0-255) the profile applies at
+	MinBrightness *float64 `json:"minBrightness,omitempty"`
+	MaxBrightness *float64 `json:"maxBrightness,omitempty"`
+	// Tuning are the detection parameters, like PUT /config/detection's
+	Tuning json.RawMessage `json:"tuning"`
+
+	from, to dayTime
+}
+
+// dayTime is a time of day, either fixed, or relative to sunrise or sunset.
+type dayTime struct {
+	// sun is "sunrise", "sunset", or "" for a fixed time
+	sun string
+	// offset is from sunrise or sunset, or from midnight for a fixed time
+	offset time.Duration
+}
+
+func parseDayTime(s string) (dayTime, error) {
+	for _, sun := range []string{"sunrise", "sunset"} {
+		rest, ok := strings.CutPrefix(s, sun)
+		if !ok {
+			continue
+		}
+
+		if rest == "" {
+			return dayTime{sun: sun}, nil
+		}
+
+		offset, err := time.ParseDuration(rest)
+		if err != nil || (rest[0] != '+' && rest[0] != '-') {
+			return dayTime{}, fmt.Errorf("invalid offset from %s %q: must be like %s+30m", sun, rest, sun)
+		}
+
+		return dayTime{sun: sun, offset: offset}, nil
+	}
+
+	offset, err := parseTimeOfDay(s)
+	if err != nil {
+		return dayTime{}, fmt.Errorf("%w: must be HH:MM, sunrise, or sunset", err)
+	}
+
+	return dayTime{offset: offset}, nil
+}
+
+// on returns the time on day (in day's location), or false when the sun
+// doesn't rise or set that day.
+func (d dayTime) on(day time.Time, lat, lon float64) (time.Time, bool) {
+	y, m, dd := day.Date()
+	midnight := time.Date(y, m, dd, 0, 0, 0, 0, day.Location())
+
+	// by the wall clock, which isn't the time since midnight on days when
+	// DST starts or ends
+	if d.sun == "" {
+		return time.Date(y, m, dd, 0, int(d.offset/time.Minute), 0, 0, day.Location()), true
+	}
+
+	rise, set, ok := sunTimes(midnight, lat, lon)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if d.sun == "sunrise" {
+		return rise.Add(d.offset), true
+	}
+
+	return set.Add(d.offset), true
+}
+
+// sunTimes returns the times of sunrise and sunset on day, at the given
+// coordinates, with the sunrise equation - accurate to a minute or two. It
+// returns false during polar day or night.
+func sunTimes(day time.Time, lat, lon float64) (rise, set time.Time, ok bool) {
+	const (
+		rad = math.Pi / 180
+		// j2000 is the Julian date of 2000-01-01 12:00 UTC, and unixEpoch of
+		// 1970-01-01 00:00 UTC
+		j2000     = 2451545.0
+		unixEpoch = 2440587.5
+	)
+
+	y, m, d := day.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + unixEpoch - j2000 + 0.0008)
+
+	// mean solar time, solar mean anomaly, equation of the center, and
+	// ecliptic longitude
+	meanTime := n - lon/360
+	anomaly := math.Mod(357.5291+0.98560028*meanTime, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.02*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	eclLon := math.Mod(anomaly+center+180+102.9372, 360)
+
+	transit := j2000 + meanTime + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*eclLon*rad)
+	declination := math.Asin(math.Sin(eclLon*rad) * math.Sin(23.4397*rad))
+
+	cosHourAngle := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*math.Sin(declination)) /
+		(math.Cos(lat*rad) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) / rad
+
+	julian := func(j float64) time.Time {
+		return time.Unix(0, int64((j-unixEpoch)*86400*float64(time.Second))).In(day.Location())
+	}
+
+	return julian(transit - hourAngle/360), julian(transit + hourAngle/360), true
+}
+
+// matches reports whether the profile applies at now, at the given frame
+// brightness (negative when not yet measured).
+func (p *detectionProfile) matches(now time.Time, brightness, lat, lon float64) bool {
+	if p.MinBrightness != nil && (brightness < 0 || brightness < *p.MinBrightness) {
+		return false
+	}
+
+	if p.MaxBrightness != nil && (brightness < 0 || brightness > *p.MaxBrightness) {
+		return false
+	}
+
+	if p.From == "" {
+		return true
+	}
+
+	from, ok := p.from.on(now, lat, lon)
+	if !ok {
+		return false
+	}
+
+	to, ok := p.to.on(now, lat, lon)
+	if !ok {
+		return false
+	}
+
+	if from.Before(to) {
+		return !now.Before(from) && now.Before(to)
+	}
+
+	// spans midnight, or all day when from and to are the same
+	return !now.Before(from) || now.Before(to)
+}
+
+// loadProfiles reads a JSON array of detection profiles.
+func loadProfiles(cfg profilesConfig) ([]*detectionProfile, error) {
+	b, err := os.ReadFile(cfg.file)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := []*detectionProfile{}
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", cfg.file, err)
+	}
+
+	for i, p := range ps {
+		if p.Name == "" {
+			return nil, fmt.Errorf("profile %d has no name", i)
+		}
+
+		if (p.From == "") != (p.To == "") {
+			return nil, fmt.Errorf("profile %s: from and to must be set together", p.Name)
+		}
+
+		if p.From == "" && p.MinBrightness == nil && p.MaxBrightness == nil {
+			return nil, fmt.Errorf("profile %s: needs a time of day (from and to) or a brightness bound", p.Name)
+		}
+
+		if p.From != "" {
+			if p.from, err = parseDayTime(p.From); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+
+			if p.to, err = parseDayTime(p.To); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+
+			if (p.from.sun != "" || p.to.sun != "") && cfg.latitude == 0 && cfg.longitude == 0 {
+				return nil, fmt.Errorf("profile %s: sunrise and sunset need -latitude and -longitude", p.Name)
+			}
+		}
+
+		// check the parameters are known - they're validated when applied
+		dec := json.NewDecoder(bytes.NewReader(p.Tuning))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&detectionTuning{}); err != nil {
+			return nil, fmt.Errorf("profile %s: invalid tuning: %w", p.Name, err)
+		}
+	}
+
+	return ps, nil
+}
+
+// profileSwitcher applies the first matching detection profile, or the base
+// parameters when none match.
+type profileSwitcher struct {
+	cfg      profilesConfig
+	loc      *time.Location
+	profiles []*detectionProfile
+
+	mu sync.Mutex
+	// base are the parameters profiles are applied over
+	base detectionTuning
+	// active is the name of the applied profile, or "" for the base
+	active string
+}
+
+func newProfileSwitcher(cfg profilesConfig, tz string) (*profileSwitcher, error) {
+	ps, err := loadProfiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := time.Local
+	if tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", tz, err)
+		}
+	}
+
+	return &profileSwitcher{cfg: cfg, loc: loc, profiles: ps, base: currentTuning()}, nil
+}
+
+// watch switches profiles as conditions change, until the context is
+// cancelled.
+func (s *profileSwitcher) watch(ctx context.Context) {
+	ticker := time.NewTicker(profilesInterval)
+	defer ticker.Stop()
+
+	for {
+		s.evaluate(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *profileSwitcher) evaluate(now time.Time) {
+	brightness := -1.0
+	if ex := exposure.status(); ex.Condition != "" {
+		brightness = ex.Brightness
+	}
+
+	var match *detectionProfile
+	for _, p := range s.profiles {
+		if p.matches(now.In(s.loc), brightness, s.cfg.latitude, s.cfg.longitude) {
+			match = p
+			break
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := ""
+	if match != nil {
+		name = match.Name
+	}
+
+	if name == s.active {
+		return
+	}
+
+	t := s.base
+	if match != nil {
+		if err := json.Unmarshal(match.Tuning, &t); err != nil {
+			slog.Error("applying detection profile", "profile", name, "err", err)
+			return
+		}
+	}
+
+	if err := applyTuning(t); err != nil {
+		slog.Error("applying detection profile", "profile", name, "err", err)
+		return
+	}
+
+	slog.Info("Switched detection profile", "profile", name, "from", s.active)
+	s.active = name
+}
+
+// tuned records parameters tuned with PUT /config/detection, and returns the
+// base parameters, to persist. When no profile is active, the tuned parameters
+// become the base for profiles - otherwise they only last until the next
+// switch.
+func (s *profileSwitcher) tuned(t detectionTuning) detectionTuning {
+	if s == nil {
+		return t
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == "" {
+		s.base = t
+	}
+
+	return s.base
+}
+
+// activeProfile returns the name of the active profile, or "".
+func (s *profileSwitcher) activeProfile() string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.active
+}
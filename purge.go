@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresTables are all the tables the PostgreSQL sink writes to
+var postgresTables = []string{
+	"presence_events",
+	"presence_occupancy",
+	"presence_expressions",
+	"presence_hourly",
+	"presence_daily",
+	"presence_expressions_hourly",
+}
+
+// runPurge implements the purge subcommand, which deletes stored data from
+// PostgreSQL - everything, or only a camera's, or only data older than a
+// given age. It only counts what would be deleted unless -yes is given, like:
+//
+//	presence purge -camera=0 -older-than=720h -yes
+func runPurge(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	dsn := fs.String("postgres-dsn", "", "PostgreSQL connection string (default $PRESENCE_POSTGRES_DSN)")
+	camera := fs.String("camera", "", "only purge data from this camera (default all cameras)")
+	olderThan := fs.Duration("older-than", 0, "only purge data older than this (default all data)")
+	yes := fs.Bool("yes", false, "delete the data - otherwise it's only counted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dsn == "" {
+		*dsn = os.Getenv("PRESENCE_POSTGRES_DSN")
+	}
+
+	if *dsn == "" {
+		return fmt.Errorf("no data store to purge: set -postgres-dsn")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	db, err := pgxpool.New(ctx, *dsn)
+	if err != nil {
+		return fmt.Errorf("connecting to PostgreSQL: %w", err)
+	}
+	defer db.Close()
+
+	conds, params := []string{"TRUE"}, []any{}
+	if *camera != "" {
+		params = append(params, *camera)
+		conds = append(conds, fmt.Sprintf("camera = $%d", len(params)))
+	}
+	if *olderThan > 0 {
+		params = append(params, time.Now().Add(-*olderThan))
+		conds = append(conds, fmt.Sprintf("time < $%d", len(params)))
+	}
+	where := strings.Join(conds, " AND ")
+
+	// purge all or nothing, so a failure doesn't leave some tables purged
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	total := int64(0)
+	for _, table := range postgresTables {
+		n, err := purgeTable(ctx, tx, table, where, params, *yes)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: %d rows\n", table, n)
+		total += n
+	}
+
+	if !*yes {
+		fmt.Printf("%d rows would be deleted - run again with -yes to delete them\n", total)
+		return nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing purge: %w", err)
+	}
+
+	fmt.Printf("%d rows deleted\n", total)
+
+	return nil
+}
+
+// purgeTable deletes (or, unless del is set, counts) the matching rows in the
+// table. Tables which haven't been created yet have no rows.
+func purgeTable(ctx context.Context, tx pgx.Tx, table, where string, params []any, del bool) (int64, error) {
+	exists := false
+	if err := tx.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("checking for %s: %w", table, err)
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	if !del {
+		n := int64(0)
+		if err := tx.QueryRow(ctx, "SELECT count(*) FROM "+table+" WHERE "+where, params...).Scan(&n); err != nil {
+			return 0, fmt.Errorf("counting %s: %w", table, err)
+		}
+
+		return n, nil
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE "+where, params...)
+	if err != nil {
+		return 0, fmt.Errorf("purging %s: %w", table, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
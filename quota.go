@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// quotaEndpoints are the ways camera frames are served, as counted by the
+// frame quota
+var quotaEndpoints = []string{"snapshot", "grpc", "mjpeg", "webrtc", "hls"}
+
+// errFrameQuota is why frames are withheld once the hourly frame quota is
+// used up
+var errFrameQuota = errors.New("hourly frame limit reached")
+
+// frameQuota counts the camera frames served through the API, and caps them
+// with -max-frames-per-hour.
+var frameQuota = &hourlyFrameQuota{served: map[string]uint64{}, refused: map[string]uint64{}}
+
+// quotaConfig holds the frame quota settings.
+type quotaConfig struct {
+	maxPerHour int
+}
+
+func (c *quotaConfig) registerFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.maxPerHour, "max-frames-per-hour", 0, "most camera frames served through the API (snapshots and streams) in any hour, across all clients (0 for no limit)")
+}
+
+func (c quotaConfig) validate() error {
+	if c.maxPerHour < 0 {
+		return fmt.Errorf("invalid -max-frames-per-hour %d: must not be negative", c.maxPerHour)
+	}
+
+	return nil
+}
+
+// hourlyFrameQuota limits the frames served in a rolling hour, counted in
+// minute buckets.
+type hourlyFrameQuota struct {
+	mu    sync.Mutex
+	limit int
+	// counts are the frames served in each minute of the hour, and minutes
+	// the minute (since the Unix epoch) each count is for
+	counts  [60]int
+	minutes [60]int64
+
+	served  map[string]uint64
+	refused map[string]uint64
+}
+
+// take counts a frame served through the endpoint, unless the quota is used
+// up, in which case it returns how long until a frame can be served again.
+func (q *hourlyFrameQuota) take(endpoint string, now time.Time) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	minute := now.Unix() / 60
+
+	if q.limit > 0 && q.used(minute) >= q.limit {
+		q.refused[endpoint]++
+
+		return false, q.retryAfter(minute, now)
+	}
+
+	i := minute % 60
+	if q.minutes[i] != minute {
+		q.counts[i], q.minutes[i] = 0, minute
+	}
+	q.counts[i]++
+	q.served[endpoint]++
+
+	return true, 0
+}
+
+// refund gives back a frame taken at the given time through the endpoint, but
+// not served after all, like when capture failed.
+func (q *hourlyFrameQuota) refund(endpoint string, taken time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	minute := taken.Unix() / 60
+	if i := minute % 60; q.minutes[i] == minute && q.counts[i] > 0 {
+		q.counts[i]--
+	}
+
+	if q.served[endpoint] > 0 {
+		q.served[endpoint]--
+	}
+}
+
+// used returns the frames served in the hour up to the minute. Callers must
+// hold q.mu.
+func (q *hourlyFrameQuota) used(minute int64) int {
+	n := 0
+	for i, m := range q.minutes {
+		if minute-m < 60 {
+			n += q.counts[i]
+		}
+	}
+
+	return n
+}
+
+// retryAfter returns how long until the oldest minute in the hour with frames
+// leaves it. Callers must hold q.mu.
+func (q *hourlyFrameQuota) retryAfter(minute int64, now time.Time) time.Duration {
+	oldest := minute
+	for i, m := range q.minutes {
+		if minute-m < 60 && q.counts[i] > 0 && m < oldest {
+			oldest = m
+		}
+	}
+
+	return time.Unix((oldest+60)*60, 0).Sub(now)
+}
+
+// frameQuotaStats are the frame quota's metrics.
+type frameQuotaStats struct {
+	Limit   int
+	Used    int
+	Served  map[string]uint64
+	Refused map[string]uint64
+}
+
+func (q *hourlyFrameQuota) stats(now time.Time) frameQuotaStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st := frameQuotaStats{
+		Limit:   q.limit,
+		Used:    q.used(now.Unix() / 60),
+		Served:  make(map[string]uint64, len(q.served)),
+		Refused: make(map[string]uint64, len(q.refused)),
+	}
+
+	for k, v := range q.served {
+		st.Served[k] = v
+	}
+
+	for k, v := range q.refused {
+		st.Refused[k] = v
+	}
+
+	return st
+}
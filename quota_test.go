@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestQuota(limit int) *hourlyFrameQuota {
+	return &hourlyFrameQuota{limit: limit, served: map[string]uint64{}, refused: map[string]uint64{}}
+}
+
+func TestHourlyFrameQuota(t *testing.T) {
+	// 15s into a minute
+	t0 := time.Unix(6000*60+15, 0)
+
+	type take struct {
+		after time.Duration
+		ok    bool
+		retry time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		takes []take
+	}{
+		{
+			name:  "no limit",
+			limit: 0,
+			takes: []take{{0, true, 0}, {0, true, 0}, {time.Minute, true, 0}},
+		},
+		{
+			name:  "refused until the oldest minute leaves the hour",
+			limit: 2,
+			takes: []take{
+				{0, true, 0},
+				{0, true, 0},
+				{0, false, time.Hour - 15*time.Second},
+				{59 * time.Minute, false, 45 * time.Second},
+				{time.Hour - 15*time.Second, true, 0},
+			},
+		},
+		{
+			name:  "minutes leave the hour one at a time",
+			limit: 2,
+			takes: []take{
+				{0, true, 0},
+				{10 * time.Minute, true, 0},
+				{30 * time.Minute, false, 30*time.Minute - 15*time.Second},
+				{time.Hour, true, 0},
+				{time.Hour, false, 10*time.Minute - 15*time.Second},
+				{70 * time.Minute, true, 0},
+			},
+		},
+		{
+			name:  "buckets are reused after an hour",
+			limit: 1,
+			takes: []take{
+				{0, true, 0},
+				{2 * time.Hour, true, 0},
+				{2*time.Hour + time.Second, false, time.Hour - 16*time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQuota(tt.limit)
+
+			served, refused := uint64(0), uint64(0)
+			for i, tk := range tt.takes {
+				ok, retry := q.take("snapshot", t0.Add(tk.after))
+				if ok != tk.ok || retry != tk.retry {
+					t.Errorf("take %d after %v = %t, %v - want %t, %v", i, tk.after, ok, retry, tk.ok, tk.retry)
+				}
+
+				if ok {
+					served++
+				} else {
+					refused++
+				}
+			}
+
+			st := q.stats(t0)
+			if st.Served["snapshot"] != served || st.Refused["snapshot"] != refused {
+				t.Errorf("stats served %d, refused %d - want %d, %d", st.Served["snapshot"], st.Refused["snapshot"], served, refused)
+			}
+		})
+	}
+}
+
+func TestHourlyFrameQuotaRefund(t *testing.T) {
+	t0 := time.Unix(6000*60, 0)
+	q := newTestQuota(1)
+
+	if ok, _ := q.take("grpc", t0); !ok {
+		t.Fatal("first frame refused")
+	}
+
+	if ok, _ := q.take("grpc", t0); ok {
+		t.Fatal("frame over the limit served")
+	}
+
+	q.refund("grpc", t0)
+
+	if st := q.stats(t0); st.Used != 0 || st.Served["grpc"] != 0 {
+		t.Errorf("after a refund, %d used and %d served, want 0", st.Used, st.Served["grpc"])
+	}
+
+	if ok, _ := q.take("grpc", t0.Add(time.Second)); !ok {
+		t.Error("frame refused after a refund")
+	}
+
+	// a refund for a minute that's left the hour doesn't touch the bucket's
+	// new minute
+	q.refund("grpc", t0.Add(-time.Hour))
+	if st := q.stats(t0); st.Used != 1 {
+		t.Errorf("%d used after refunding an old frame, want 1", st.Used)
+	}
+}
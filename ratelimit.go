@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long a client's rate limiter is kept after its last
+// request
+const limiterIdle = 5 * time.Minute
+
+// limitConfig holds the settings for limiting image requests.
+type limitConfig struct {
+	rate        float64
+	burst       int
+	concurrency int
+}
+
+func (c *limitConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&c.rate, "image-rate", 5, "image requests per second allowed from each client (0 for no limit)")
+	fs.IntVar(&c.burst, "image-burst", 10, "image requests allowed in a burst from each client")
+	fs.IntVar(&c.concurrency, "image-concurrency", 2, "image requests handled at once, across all clients (0 for no limit)")
+}
+
+// requestLimiter limits how often each client can request images, and how
+// many are captured at once, so that a misbehaving dashboard can't starve the
+// detection loop of the camera.
+type requestLimiter struct {
+	cfg limitConfig
+	sem chan struct{}
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newRequestLimiter(cfg limitConfig) *requestLimiter {
+	l := &requestLimiter{cfg: cfg, clients: map[string]*clientLimiter{}}
+	if cfg.concurrency > 0 {
+		l.sem = make(chan struct{}, cfg.concurrency)
+	}
+
+	return l
+}
+
+// limited wraps an image handler with the image limits.
+func limited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := imageLimiter.allow(clientAddr(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		release, ok := imageLimiter.acquire()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent image requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		h(w, r)
+	}
+}
+
+// allow reports whether the client may make a request now, and if not, how
+// long until it may.
+func (l *requestLimiter) allow(client string) (bool, time.Duration) {
+	if l == nil || l.cfg.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{Limiter: rate.NewLimiter(rate.Limit(l.cfg.rate), max(1, l.cfg.burst))}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+
+	res := c.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// sweep forgets clients which haven't made requests in a while. Callers must
+// hold l.mu.
+func (l *requestLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterIdle {
+		return
+	}
+
+	for k, c := range l.clients {
+		if now.Sub(c.lastSeen) > limiterIdle {
+			delete(l.clients, k)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+// acquire claims one of the concurrent image request slots, returning a
+// function to release it. It fails immediately when all slots are in use.
+func (l *requestLimiter) acquire() (func(), bool) {
+	if l == nil || l.sem == nil {
+		return func() {}, true
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		return func() { <-l.sem }, true
+	default:
+		return nil, false
+	}
+}
+
+// clientAddr identifies the client making the request, by IP address.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
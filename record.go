@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// recorder records every analyzed frame's detections, when -record is set.
+// It's nil otherwise.
+var recorder *detectionRecorder
+
+// recordConfig holds the detection recording settings.
+type recordConfig struct {
+	file       string
+	maxSize    int
+	maxBackups int
+}
+
+func (c *recordConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "record", "", "JSONL file to record every analyzed frame's detections to, for debugging and presence replay")
+	fs.IntVar(&c.maxSize, "record-max-size", 100, "size in megabytes at which -record is rotated")
+	fs.IntVar(&c.maxBackups, "record-max-backups", 3, "number of rotated recordings to keep")
+}
+
+func (c recordConfig) enabled() bool {
+	return c.file != ""
+}
+
+// maxRecordSize is the longest line a detection recording can have
+const maxRecordSize = 1 << 20
+
+// detectionRecord is the result of analyzing a frame, as a line of a JSONL
+// detection recording, which presence replay can feed back through the
+// presence state machine.
+type detectionRecord struct {
+	Time   time.Time `json:"time"`
+	Camera string    `json:"camera"`
+	// Width and Height are the frame's size, to place detections in zones
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Frame is a hash of the frame's pixels, to tell identical frames apart
+	// from a stuck camera
+	Frame string `json:"frame,omitempty"`
+	// Reused is set when the scene hadn't changed, so the last analyzed
+	// frame's detections were reused
+	Reused     bool            `json:"reused,omitempty"`
+	Detections []faceDetection `json:"detections"`
+}
+
+// detectionRecorder writes detection records to a rotated file.
+type detectionRecorder struct {
+	mu  sync.Mutex
+	out *lumberjack.Logger
+	enc *json.Encoder
+}
+
+func newDetectionRecorder(c recordConfig) *detectionRecorder {
+	out := &lumberjack.Logger{
+		Filename:   c.file,
+		MaxSize:    c.maxSize,
+		MaxBackups: c.maxBackups,
+	}
+
+	return &detectionRecorder{out: out, enc: json.NewEncoder(out)}
+}
+
+// hash returns a hash of the frame's pixels, or "" when not recording.
+func (r *detectionRecorder) hash(frame gocv.Mat) string {
+	if r == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(frame.ToBytes())
+
+	return hex.EncodeToString(sum[:8])
+}
+
+func (r *detectionRecorder) record(rec detectionRecord) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.enc.Encode(rec); err != nil {
+		slog.Error("recording detections", "err", err)
+	}
+}
+
+func (r *detectionRecorder) close() error {
+	return r.out.Close()
+}
+
+// readRecords calls fn with each record in a JSONL detection recording, in
+// order.
+func readRecords(r io.Reader, fn func(rec detectionRecord) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		rec := detectionRecord{}
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
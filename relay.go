@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// relayDevice is the -device which reads frames from the relay
+	relayDevice = "relay"
+	// relayStale is how old the latest relayed frame can be before the relay
+	// is considered disconnected
+	relayStale = 5 * time.Second
+	// relayMaxFrame limits the size of relayed frames
+	relayMaxFrame = 16 << 20
+)
+
+// relayConfig holds the settings for receiving frames from remote
+// capture-only instances (see the relay subcommand), over mutually
+// authenticated TLS.
+type relayConfig struct {
+	listen   string
+	cert     string
+	key      string
+	clientCA string
+}
+
+func (c *relayConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.listen, "relay-listen", "", "address to receive relayed frames on, with -device=relay (default disabled)")
+	fs.StringVar(&c.cert, "relay-cert", "", "TLS certificate for -relay-listen")
+	fs.StringVar(&c.key, "relay-key", "", "TLS private key for -relay-listen")
+	fs.StringVar(&c.clientCA, "relay-client-ca", "", "CA certificate which relay clients' certificates must be signed by")
+}
+
+func (c relayConfig) enabled() bool {
+	return c.listen != ""
+}
+
+// relayed holds the latest relayed frame
+var relayed = &relayReceiver{}
+
+// relayReceiver receives JPEG frames from relays, and acts as the capture
+// device when -device=relay.
+type relayReceiver struct {
+	mu     sync.Mutex
+	frame  []byte
+	at     time.Time
+	client string
+}
+
+// Read decodes the latest relayed frame into m, failing when there's no
+// recent frame.
+func (r *relayReceiver) Read(m *gocv.Mat) bool {
+	r.mu.Lock()
+	frame, at := r.frame, r.at
+	r.mu.Unlock()
+
+	if frame == nil || time.Since(at) > relayStale {
+		return false
+	}
+
+	img, err := gocv.IMDecode(frame, gocv.IMReadColor)
+	if err != nil {
+		return false
+	}
+	defer img.Close()
+
+	img.CopyTo(m)
+
+	return !m.Empty()
+}
+
+// Close does nothing, as the relay keeps receiving frames.
+func (r *relayReceiver) Close() error {
+	return nil
+}
+
+// handleRelayFrame receives a JPEG frame from a relay.
+func (r *relayReceiver) handleRelayFrame(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := io.ReadAll(http.MaxBytesReader(w, req.Body, relayMaxFrame))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := ""
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		client = req.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	r.mu.Lock()
+	if r.client != client {
+		slog.Info("Receiving relayed frames", "client", client, "remote", req.RemoteAddr)
+		r.client = client
+	}
+	r.frame, r.at = b, time.Now()
+	r.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveRelay receives relayed frames until the context is cancelled. Clients
+// must present a certificate signed by the client CA.
+func serveRelay(ctx context.Context, cfg relayConfig) error {
+	if cfg.cert == "" || cfg.key == "" || cfg.clientCA == "" {
+		return errors.New("-relay-listen needs -relay-cert, -relay-key, and -relay-client-ca")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.cert, cfg.key)
+	if err != nil {
+		return fmt.Errorf("loading relay certificate: %w", err)
+	}
+
+	pool, err := loadCertPool(cfg.clientCA)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/frame", relayed.handleRelayFrame)
+
+	srv := &http.Server{
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	l, err := net.Listen("tcp", cfg.listen)
+	if err != nil {
+		return fmt.Errorf("listening for relays: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	go func() {
+		if err := srv.ServeTLS(l, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("relay server stopped", "err", err)
+		}
+	}()
+
+	slog.Info("Receiving relayed frames at https://" + l.Addr().String() + "/frame")
+
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// runRelay implements the relay subcommand, a capture-only instance which
+// sends frames to a central instance for detection, like:
+//
+//	presence relay -to=https://central:8443 -cert=relay.crt -key=relay.key -ca=ca.crt
+func runRelay(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	to := fs.String("to", "", "base URL of the central instance's -relay-listen address, like https://central:8443")
+	certFile := fs.String("cert", "", "TLS client certificate")
+	keyFile := fs.String("key", "", "TLS client private key")
+	caFile := fs.String("ca", "", "CA certificate which the central instance's certificate must be signed by")
+	fps := fs.Float64("fps", 2, "frames per second to relay")
+	fs.StringVar(&device, "device", device, "video capture device ID, or a video stream URL like rtsp://camera/stream")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *to == "" || *certFile == "" || *keyFile == "" || *caFile == "" {
+		return errors.New("-to, -cert, -key, and -ca are required")
+	}
+
+	if *fps <= 0 {
+		return fmt.Errorf("invalid -fps %v: must be positive", *fps)
+	}
+
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		return fmt.Errorf("loading client certificate: %w", err)
+	}
+
+	pool, err := loadCertPool(*caFile)
+	if err != nil {
+		return err
+	}
+
+	hc := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+				MinVersion:   tls.VersionTLS12,
+			},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	vc, err := gocv.OpenVideoCapture(device)
+	if err != nil {
+		return fmt.Errorf("opening capture device %s: %w", redactURL(device), err)
+	}
+	defer vc.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *fps))
+	defer ticker.Stop()
+
+	slog.Info("Relaying frames", "to", *to, "device", redactURL(device))
+
+	failing := false
+	for range ticker.C {
+		if ok := vc.Read(&img); !ok || img.Empty() {
+			return fmt.Errorf("device closed: %v", redactURL(device))
+		}
+
+		b, err := encodeJPEG(img)
+		if err != nil {
+			return fmt.Errorf("encoding frame: %w", err)
+		}
+
+		err = relayFrame(hc, *to, b)
+		if err != nil && !failing {
+			slog.Warn("relaying frame", "err", err)
+		} else if err == nil && failing {
+			slog.Info("Relaying frames again")
+		}
+		failing = err != nil
+	}
+
+	return nil
+}
+
+func relayFrame(hc *http.Client, base string, frame []byte) error {
+	resp, err := hc.Post(base+"/frame", "image/jpeg", bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("relay returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
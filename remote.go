@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hairyhenderson/presence/client"
+)
+
+// remoteConfig holds the settings for aggregating remote presence instances.
+type remoteConfig struct {
+	remotes   stringsFlag
+	interval  time.Duration
+	freshness time.Duration
+	weight    float64
+	apiKey    string
+	// only disables the local camera, for an instance which only aggregates
+	only bool
+}
+
+func (c *remoteConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Var(&c.remotes, "remote", "base URL of a remote presence instance to aggregate, optionally named like hallway=http://pi:8888; may be repeated")
+	fs.DurationVar(&c.interval, "remote-interval", 5*time.Second, "how often to poll remote instances")
+	fs.DurationVar(&c.freshness, "remote-freshness", 15*time.Second, "freshness window: how long a remote instance counts as present after it last reported presence")
+	fs.Float64Var(&c.weight, "remote-weight", 1, "weight of each remote instance's presence in the fusion policy")
+	fs.StringVar(&c.apiKey, "remote-api-key", "", "API key (with the status scope) for remote instances which require one")
+	fs.BoolVar(&c.only, "aggregate-only", false, "don't use a local camera - only aggregate -remote instances")
+}
+
+func (c remoteConfig) enabled() bool {
+	return len(c.remotes) > 0
+}
+
+// remoteTimeout bounds each poll of a remote instance
+const remoteTimeout = 10 * time.Second
+
+// remotes are the aggregated remote instances, for the dashboard
+var remotes []*remoteInstance
+
+// remoteInstance is a signal which is active while a remote presence
+// instance reports presence.
+type remoteInstance struct {
+	name string
+	url  string
+	c    *client.Client
+
+	mu     sync.Mutex
+	last   time.Time
+	status *client.Status
+	err    error
+}
+
+// newRemoteInstances parses the -remote flags, which are base URLs, or
+// name=URL.
+func newRemoteInstances(cfg remoteConfig) ([]*remoteInstance, error) {
+	if cfg.interval <= 0 {
+		return nil, fmt.Errorf("invalid -remote-interval %v: must be positive", cfg.interval)
+	}
+
+	instances := []*remoteInstance{}
+	hc := &http.Client{Timeout: remoteTimeout}
+
+	for _, r := range cfg.remotes {
+		name, u, ok := strings.Cut(r, "=")
+		if !ok {
+			u = r
+			name = r
+		}
+
+		c, err := client.NewClient(u, client.WithHTTPClient(hc), client.WithAPIKey(cfg.apiKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -remote: %w", err)
+		}
+
+		instances = append(instances, &remoteInstance{name: name, url: strings.TrimSuffix(u, "/"), c: c})
+	}
+
+	return instances, nil
+}
+
+func (r *remoteInstance) lastActive() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
+
+// watch polls the remote instance every interval, until the context is
+// cancelled.
+func (r *remoteInstance) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		st, err := r.c.Status(ctx)
+
+		r.mu.Lock()
+		if err != nil && r.err == nil {
+			slog.Warn("polling remote presence instance", "remote", r.name, "err", err)
+		}
+		r.err = err
+		if err == nil {
+			r.status = st
+			if st.Present {
+				r.last = time.Now()
+			}
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// aggregate evaluates the fused presence every interval, until the context is
+// cancelled, for -aggregate-only instances - with no frames to observe,
+// nothing else would.
+func aggregate(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			tracker.evaluate(now)
+		}
+	}
+}
+
+// remoteView is a remote instance's state, for the dashboard.
+type remoteView struct {
+	Name  string
+	URL   string
+	State string
+	Since time.Time
+	Faces int
+	Err   string
+}
+
+func (r *remoteInstance) view() remoteView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v := remoteView{Name: r.name, URL: r.url, State: "unknown"}
+	if r.status != nil {
+		v.State, v.Since, v.Faces = r.status.State, r.status.Since, r.status.Faces
+	}
+	if r.err != nil {
+		v.State, v.Err = "unreachable", r.err.Error()
+	}
+
+	return v
+}
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+
+		return time.Since(t).Round(time.Second).String()
+	},
+}).Parse(dashboardHTML))
+
+// handleDashboard renders the combined state, and each instance's state and
+// latest frame. Any API key in the query is passed on to the local frame.
+func handleDashboard(w http.ResponseWriter, req *http.Request) {
+	st := currentStatus()
+
+	data := struct {
+		Status  statusResponse
+		Local   bool
+		Key     string
+		Remotes []remoteView
+	}{Status: st, Local: !remoteCfg.only, Key: req.URL.Query().Get("key")}
+
+	for _, r := range remotes {
+		data.Remotes = append(data.Remotes, r.view())
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Error("rendering dashboard", "err", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runReplay implements the replay subcommand, which feeds a detection
+// recording back through the presence state machine, rules, and outputs, to
+// try out settings against real data, like:
+//
+//	presence replay -events detections.jsonl -speed 10x -away-timeout 2m -rules rules.json
+//
+// Events are printed to stdout as JSON lines. Rules' webhooks are only fired
+// with -fire.
+func runReplay(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	file := fs.String("events", "", "JSONL detection recording to replay, as written by -record (- for stdin)")
+	speedFlag := fs.String("speed", "0", "playback speed relative to the recording, like 10x (0 to replay as fast as possible)")
+	fire := fs.Bool("fire", false, "fire rules' webhooks when they match - otherwise matches are only logged")
+	fs.DurationVar(&awayTimeout, "away-timeout", awayTimeout, "time without a detected face before presence is considered departed")
+	fs.Var(&awayTimeoutSpecs, "away-timeout-at", "away timeout during a window, like \"Mon-Fri 09:00-17:00=2m\"; may be repeated")
+	fs.StringVar(&scheduleTZ, "timezone", "", "timezone for -away-timeout-at (default local time)")
+	fs.DurationVar(&cameraFreshness, "camera-freshness", cameraFreshness, "freshness window: how long a camera face detection counts towards presence")
+	zoneCfg.registerFlags(fs)
+	fs.StringVar(&rulesFile, "rules", "", "JSON file of automation rules to evaluate")
+	fs.StringVar(&pluginsDir, "plugins-dir", "", "directory of executables to run for each event")
+	pluginsThrottle.registerFlags(fs, "plugins", "plugin runs")
+	templateCfg.registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(*speedFlag, "x"), 64)
+	if err != nil || speed < 0 {
+		return fmt.Errorf("invalid -speed %q: must be like 10x", *speedFlag)
+	}
+
+	var in io.Reader
+	switch *file {
+	case "":
+		return fmt.Errorf("no recording given: set -events")
+	case "-":
+		in = os.Stdin
+	default:
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	timeouts, err := parseAwayTimeouts(awayTimeoutSpecs, scheduleTZ, awayTimeout)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var (
+		rp    *replayer
+		start time.Time
+	)
+
+	err = readRecords(in, func(rec detectionRecord) error {
+		if rp == nil {
+			// the tracker is named after the recorded camera
+			rp, err = newReplayer(rec.Camera, timeouts, *fire)
+			if err != nil {
+				return err
+			}
+			start = rec.Time
+		}
+
+		rp.replay(ctx, rec, speed)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("replaying %s: %w", *file, err)
+	}
+
+	if rp == nil {
+		return fmt.Errorf("%s has no records", *file)
+	}
+
+	fmt.Fprintf(os.Stderr, "Replayed %d frames from %s to %s, with %d events\n",
+		rp.frames, start.Format(time.RFC3339), rp.last.Format(time.RFC3339), rp.out.count)
+
+	return nil
+}
+
+// replayer feeds records to a presence tracker.
+type replayer struct {
+	tracker *presenceTracker
+	rules   *rulesEngine
+	out     *eventPrinter
+
+	frames int
+	last   time.Time
+}
+
+func newReplayer(camera string, timeouts *awayTimeouts, fire bool) (*replayer, error) {
+	rp := &replayer{
+		tracker: newPresenceTracker(camera, timeouts, cameraWeight, cameraFreshness, fusionThreshold),
+		out:     &eventPrinter{enc: json.NewEncoder(os.Stdout)},
+	}
+
+	rp.tracker.addNotifier(rp.out)
+	rp.tracker.addZoneNotifier(rp.out)
+
+	if zoneCfg.enabled() {
+		zones, err := parseZones(zoneCfg.specs)
+		if err != nil {
+			return nil, err
+		}
+		rp.tracker.setZones(zones)
+	}
+
+	if templateCfg.enabled() {
+		n, err := newTemplateNotifier(templateCfg)
+		if err != nil {
+			return nil, fmt.Errorf("configuring templated output: %w", err)
+		}
+		rp.tracker.addNotifier(templateCfg.throttle.throttle("template", n))
+	}
+
+	if pluginsDir != "" {
+		n, err := newPluginNotifier(pluginsDir)
+		if err != nil {
+			return nil, fmt.Errorf("configuring plugins: %w", err)
+		}
+
+		tn := pluginsThrottle.throttle("plugins", n)
+		rp.tracker.addNotifier(tn)
+		rp.tracker.addZoneNotifier(tn)
+	}
+
+	if rulesFile != "" {
+		rules, err := loadRules(rulesFile, rp.tracker)
+		if err != nil {
+			return nil, fmt.Errorf("loading rules: %w", err)
+		}
+		rules.dryRun = !fire
+
+		rp.rules = rules
+		rp.tracker.addNotifier(rules)
+	}
+
+	return rp, nil
+}
+
+// replay observes a record, after waiting for the time since the last one,
+// scaled down by speed (unless it's 0). Rules are evaluated at the record's
+// time, as they would be periodically by the daemon.
+func (rp *replayer) replay(ctx context.Context, rec detectionRecord, speed float64) {
+	if speed > 0 && !rp.last.IsZero() {
+		if d := rec.Time.Sub(rp.last); d > 0 {
+			time.Sleep(time.Duration(float64(d) / speed))
+		}
+	}
+	rp.last = rec.Time
+	rp.frames++
+
+	rp.tracker.observe(rec.Time, image.Pt(rec.Width, rec.Height), rec.Detections, func() ([]byte, error) {
+		return nil, nil
+	})
+
+	// keep events in order
+	rp.tracker.wait()
+
+	if rp.rules != nil {
+		st := rp.tracker.status()
+		rp.rules.evaluate(ctx, ruleVars(st.State(), "", rec.Time.Sub(st.Since), st.Faces, st.Camera, rec.Time))
+	}
+}
+
+// eventPrinter writes events to stdout as JSON lines.
+type eventPrinter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	count int
+}
+
+func (p *eventPrinter) notify(_ context.Context, ev event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.count++
+
+	return p.enc.Encode(ev)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// rulesInterval is how often rules are evaluated between events, so that
+// time-based conditions (e.g. "away for more than 30 minutes") can fire.
+const rulesInterval = 10 * time.Second
+
+// rule is a CEL condition and the webhook to fire when it becomes true.
+//
+// Conditions have access to these variables:
+//
+//	state    - "present", "away", or "unknown"
+//	event    - "arrival" or "departure" when evaluated for an event, otherwise ""
+//	duration - how long the current state has lasted
+//	faces    - the number of faces in the most recent frame
+//	camera   - the camera the state relates to
+//	now      - the current time
+//	hour, minute, weekday - the current local time (weekday 0 is Sunday)
+//
+// For example:
+//
+//	state == "away" && duration > duration("30m") && hour >= 9 && hour < 17 && weekday >= 1 && weekday <= 5
+type rule struct {
+	Name    string `json:"name"`
+	When    string `json:"when"`
+	Webhook string `json:"webhook"`
+
+	prg cel.Program
+	// active is true while the condition holds, so that the webhook is only
+	// fired when the condition becomes true, not on every evaluation
+	active bool
+}
+
+type rulesEngine struct {
+	mu      sync.Mutex
+	rules   []*rule
+	tracker *presenceTracker
+	hc      *http.Client
+	// dryRun logs matching rules without firing their webhooks
+	dryRun bool
+}
+
+func newRulesEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("state", cel.StringType),
+		cel.Variable("event", cel.StringType),
+		cel.Variable("duration", cel.DurationType),
+		cel.Variable("faces", cel.IntType),
+		cel.Variable("camera", cel.StringType),
+		cel.Variable("now", cel.TimestampType),
+		cel.Variable("hour", cel.IntType),
+		cel.Variable("minute", cel.IntType),
+		cel.Variable("weekday", cel.IntType),
+	)
+}
+
+// loadRules reads a JSON array of rules from the given file and compiles
+// them.
+func loadRules(path string, tracker *presenceTracker) (*rulesEngine, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules: %w", err)
+	}
+
+	rules := []*rule{}
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("parsing rules %s: %w", path, err)
+	}
+
+	env, err := newRulesEnv()
+	if err != nil {
+		return nil, fmt.Errorf("creating rules environment: %w", err)
+	}
+
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if r.Webhook == "" {
+			return nil, fmt.Errorf("rule %s: missing webhook", r.Name)
+		}
+
+		ast, iss := env.Compile(r.When)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, iss.Err())
+		}
+
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("rule %s: condition must be a boolean, not %s", r.Name, ast.OutputType())
+		}
+
+		r.prg, err = env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+
+	return &rulesEngine{
+		rules:   rules,
+		tracker: tracker,
+		hc:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// watch evaluates the rules periodically, until the context is cancelled.
+func (e *rulesEngine) watch(ctx context.Context) {
+	ticker := time.NewTicker(rulesInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			st := e.tracker.status()
+
+			e.evaluate(ctx, ruleVars(st.State(), "", now.Sub(st.Since), st.Faces, st.Camera, now))
+		}
+	}
+}
+
+func (e *rulesEngine) notify(ctx context.Context, ev event) error {
+	e.evaluate(ctx, ruleVars(ev.State, string(ev.Type), 0, ev.Faces, ev.Camera, ev.Time))
+
+	return nil
+}
+
+func ruleVars(state, ev string, d time.Duration, faces int, camera string, now time.Time) map[string]any {
+	now = now.Local()
+
+	return map[string]any{
+		"state":    state,
+		"event":    ev,
+		"duration": d,
+		"faces":    faces,
+		"camera":   camera,
+		"now":      now,
+		"hour":     now.Hour(),
+		"minute":   now.Minute(),
+		"weekday":  int(now.Weekday()),
+	}
+}
+
+func (e *rulesEngine) evaluate(ctx context.Context, vars map[string]any) {
+	fired := []*rule{}
+
+	e.mu.Lock()
+	for _, r := range e.rules {
+		out, _, err := r.prg.Eval(vars)
+		if err != nil {
+			slog.Warn("evaluating rule", "rule", r.Name, "err", err)
+			continue
+		}
+
+		match, _ := out.Value().(bool)
+		if match && !r.active {
+			fired = append(fired, r)
+		}
+		r.active = match
+	}
+	e.mu.Unlock()
+
+	for _, r := range fired {
+		slog.Info("Rule matched", "rule", r.Name, "time", vars["now"])
+
+		if e.dryRun {
+			continue
+		}
+
+		if err := e.fire(ctx, r, vars); err != nil {
+			slog.Error("firing rule webhook", "rule", r.Name, "err", err)
+		}
+	}
+}
+
+func (e *rulesEngine) fire(ctx context.Context, r *rule, vars map[string]any) error {
+	payload, err := json.Marshal(map[string]any{
+		"rule":     r.Name,
+		"state":    vars["state"],
+		"event":    vars["event"],
+		"duration": vars["duration"].(time.Duration).Seconds(),
+		"faces":    vars["faces"],
+		"camera":   vars["camera"],
+		"time":     vars["now"],
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook %s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeRules writes a rules file, returning its path.
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRuleConditions(t *testing.T) {
+	// a Friday
+	now := time.Date(2024, 5, 3, 10, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		when string
+		vars map[string]any
+		want bool
+	}{
+		{`state == "away"`, ruleVars("away", "", 0, 0, "0", now), true},
+		{`state == "away"`, ruleVars("present", "", 0, 1, "0", now), false},
+		{`event == "arrival" && faces >= 2`, ruleVars("present", "arrival", 0, 2, "0", now), true},
+		{`event == "arrival" && faces >= 2`, ruleVars("present", "", 0, 2, "0", now), false},
+		{`state == "away" && duration > duration("30m")`, ruleVars("away", "", 31*time.Minute, 0, "0", now), true},
+		{`state == "away" && duration > duration("30m")`, ruleVars("away", "", 29*time.Minute, 0, "0", now), false},
+		{`hour >= 9 && hour < 17 && weekday >= 1 && weekday <= 5`, ruleVars("away", "", 0, 0, "0", now), true},
+		{`weekday == 0 || weekday == 6`, ruleVars("away", "", 0, 0, "0", now), false},
+		{`minute == 30 && camera == "desk"`, ruleVars("away", "", 0, 0, "desk", now), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			when, _ := json.Marshal(tt.when)
+			path := writeRules(t, `[{"name": "test", "when": `+string(when)+`, "webhook": "http://127.0.0.1:1/"}]`)
+
+			e, err := loadRules(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.dryRun = true
+
+			e.evaluate(context.Background(), tt.vars)
+			if got := e.rules[0].active; got != tt.want {
+				t.Errorf("matched %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := []struct {
+		name, rules, want string
+	}{
+		{"not JSON", `{`, "parsing rules"},
+		{"no webhook", `[{"name": "a", "when": "true"}]`, "missing webhook"},
+		{"syntax error", `[{"name": "a", "when": "state ==", "webhook": "http://x/"}]`, "rule a"},
+		{"unknown variable", `[{"name": "a", "when": "colour == \"red\"", "webhook": "http://x/"}]`, "undeclared reference"},
+		{"not a boolean", `[{"name": "a", "when": "faces + 1", "webhook": "http://x/"}]`, "must be a boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadRules(writeRules(t, tt.rules), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleFiresOnlyWhenBecomingTrue(t *testing.T) {
+	fired := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fired.Add(1)
+	}))
+	defer srv.Close()
+
+	e, err := loadRules(writeRules(t, `[{"when": "state == \"away\"", "webhook": "`+srv.URL+`"}]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.rules[0].Name != "rule-0" {
+		t.Errorf("unnamed rule is called %q, want rule-0", e.rules[0].Name)
+	}
+
+	now := time.Now()
+	for i, state := range []string{"away", "away", "present", "away", "away"} {
+		e.evaluate(context.Background(), ruleVars(state, "", time.Duration(i)*time.Minute, 0, "0", now))
+	}
+
+	if n := fired.Load(); n != 2 {
+		t.Errorf("webhook fired %d times, want 2", n)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocv.io/x/gocv"
+	"golang.org/x/sync/singleflight"
+)
+
+// sceneThumbWidth is the width frames are scaled down to for comparison -
+// small enough to be cheap, and to smooth out sensor noise
+const sceneThumbWidth = 64
+
+// sceneConfig holds the settings for skipping analysis of unchanged frames.
+type sceneConfig struct {
+	threshold float64
+	maxAge    time.Duration
+}
+
+func (c *sceneConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&c.threshold, "static-threshold", 1.5, "mean per-pixel difference (0-255) from the last analyzed frame below which a frame is considered unchanged, and its detections and annotated JPEG are reused (0 to always analyze)")
+	fs.DurationVar(&c.maxAge, "static-max-age", 10*time.Second, "how long detections can be reused for an unchanged scene before frames are analyzed again")
+}
+
+// scene caches the results of the last analyzed frame, so that they can be
+// reused while the scene doesn't change, skipping the classifiers and JPEG
+// encoding.
+var scene = &sceneCache{}
+
+// sceneCache holds the last analyzed frame's results. The frames and
+// detections are guarded by webcamMu, and the JPEGs and metadata by mu.
+type sceneCache struct {
+	cfg sceneConfig
+
+	// thumb is the scaled-down grayscale analyzed frame, for comparison
+	thumb gocv.Mat
+	// clean is the analyzed frame, without annotations
+	clean      gocv.Mat
+	annotated  gocv.Mat
+	detections []faceDetection
+	analyzed   time.Time
+	valid      bool
+
+	mu sync.Mutex
+	// gen identifies the analyzed frame, so JPEGs are only cached for the
+	// frame they were encoded from
+	gen   uint64
+	jpegs map[jpegKey][]byte
+	meta  frameMeta
+
+	// flight deduplicates concurrent encodes of the same frame and width
+	flight singleflight.Group
+	// hits and misses count JPEGs served from the cache (or a concurrent
+	// encode), and JPEGs encoded
+	hits, misses atomic.Uint64
+}
+
+// jpegKey identifies an encoding of the analyzed frame
+type jpegKey struct {
+	width int
+	clean bool
+}
+
+// reuse replaces frame with the last annotated frame, and clean (if not nil)
+// with the last unannotated frame, returning its detections, when frame hasn't
+// changed enough to need analyzing. Otherwise it returns ok false, and a
+// thumbnail of the frame to pass to store.
+func (c *sceneCache) reuse(frame, clean *gocv.Mat, now time.Time) (detections []faceDetection, thumb gocv.Mat, ok bool) {
+	thumb = sceneThumb(*frame)
+
+	if c.cfg.threshold <= 0 || !c.valid || now.Sub(c.analyzed) > c.cfg.maxAge {
+		return nil, thumb, false
+	}
+
+	// the camera's resolution can change, like when it's reopened
+	if thumb.Rows() != c.thumb.Rows() || thumb.Cols() != c.thumb.Cols() {
+		return nil, thumb, false
+	}
+
+	diff := gocv.NewMat()
+	defer diff.Close()
+	gocv.AbsDiff(thumb, c.thumb, &diff)
+
+	if diff.Mean().Val1 >= c.cfg.threshold {
+		return nil, thumb, false
+	}
+
+	thumb.Close()
+	c.annotated.CopyTo(frame)
+	if clean != nil {
+		c.clean.CopyTo(clean)
+	}
+
+	return c.detections, gocv.Mat{}, true
+}
+
+// store records the results of analyzing a frame, taking ownership of thumb
+// and clean, and returns the new generation.
+func (c *sceneCache) store(thumb, clean, annotated gocv.Mat, detections []faceDetection, now time.Time) uint64 {
+	c.close()
+
+	c.thumb = thumb
+	c.clean = clean
+	c.annotated = annotated.Clone()
+	c.detections = detections
+	c.analyzed = now
+	c.valid = true
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.jpegs = map[jpegKey][]byte{}
+	c.meta = frameMeta{
+		Frame:      c.gen,
+		Time:       now,
+		Width:      annotated.Cols(),
+		Height:     annotated.Rows(),
+		Detections: detections,
+	}
+
+	return c.gen
+}
+
+// metadata returns the metadata of the last analyzed frame, which is zero
+// when there isn't one.
+func (c *sceneCache) metadata() frameMeta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.meta
+}
+
+// generation returns the current generation.
+func (c *sceneCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// reset discards the cached frame, like when the camera is closed.
+func (c *sceneCache) reset() {
+	c.close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.jpegs = nil
+	c.meta = frameMeta{}
+}
+
+func (c *sceneCache) close() {
+	if !c.valid {
+		return
+	}
+
+	c.thumb.Close()
+	c.clean.Close()
+	c.annotated.Close()
+	c.valid = false
+}
+
+// encode returns the frame of the given generation - annotated, or clean -
+// as JPEGs at each of the given widths. Each is only encoded once per
+// generation, even when requested concurrently.
+func (c *sceneCache) encode(gen uint64, imgMat gocv.Mat, widths []int, clean bool) (map[int][]byte, error) {
+	imgs := make(map[int][]byte, len(widths))
+
+	for _, w := range widths {
+		key := jpegKey{width: w, clean: clean}
+
+		c.mu.Lock()
+		img, ok := c.jpegs[key]
+		ok = ok && gen == c.gen
+		c.mu.Unlock()
+
+		if ok {
+			c.hits.Add(1)
+			imgs[w] = img
+
+			continue
+		}
+
+		encoded := false
+		v, err, _ := c.flight.Do(fmt.Sprintf("%d/%d/%t", gen, w, clean), func() (any, error) {
+			encoded = true
+			c.misses.Add(1)
+
+			img, err := encodeJPEGs(imgMat, []int{w})
+			if err != nil {
+				return nil, err
+			}
+
+			c.mu.Lock()
+			if gen == c.gen && c.jpegs != nil {
+				c.jpegs[key] = img[w]
+			}
+			c.mu.Unlock()
+
+			return img[w], nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !encoded {
+			c.hits.Add(1)
+		}
+
+		imgs[w] = v.([]byte)
+	}
+
+	return imgs, nil
+}
+
+// sceneThumb scales the frame down to a small grayscale image.
+func sceneThumb(frame gocv.Mat) gocv.Mat {
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(frame, &gray, gocv.ColorBGRToGray)
+
+	thumb := gocv.NewMat()
+	if frame.Cols() == 0 {
+		return thumb
+	}
+
+	height := max(1, frame.Rows()*sceneThumbWidth/frame.Cols())
+	gocv.Resize(gray, &thumb, image.Pt(sceneThumbWidth, height), 0, 0, gocv.InterpolationArea)
+
+	return thumb
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// schedule is a set of weekly windows during which capture is active.
+type schedule struct {
+	loc     *time.Location
+	windows []scheduleWindow
+}
+
+type scheduleWindow struct {
+	days [7]bool
+	// start and end are offsets from midnight - when end is before start the
+	// window spans midnight, and when they're equal it lasts 24 hours
+	start, end time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseSchedule parses windows of the form "[DAYS] HH:MM-HH:MM", where DAYS is
+// a comma-separated list of days or day ranges, like "Mon-Fri" or "Sat,Sun".
+// Windows without days apply every day, and windows which end when they start,
+// like "Sat 00:00-00:00", last 24 hours. An empty timezone means local time.
+func parseSchedule(specs []string, tz string) (*schedule, error) {
+	s := &schedule{loc: time.Local}
+
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", tz, err)
+		}
+		s.loc = loc
+	}
+
+	for _, spec := range specs {
+		w, err := parseScheduleWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+
+	return s, nil
+}
+
+func parseScheduleWindow(spec string) (scheduleWindow, error) {
+	w := scheduleWindow{}
+
+	fields := strings.Fields(spec)
+
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		days, hours = "*", fields[0]
+	case 2:
+		days, hours = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("expected \"[DAYS] HH:MM-HH:MM\"")
+	}
+
+	if err := w.parseDays(days); err != nil {
+		return w, err
+	}
+
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return w, fmt.Errorf("expected a time range like 08:00-18:00")
+	}
+
+	var err error
+	if w.start, err = parseTimeOfDay(from); err != nil {
+		return w, err
+	}
+
+	if w.end, err = parseTimeOfDay(to); err != nil {
+		return w, err
+	}
+
+	return w, nil
+}
+
+func (w *scheduleWindow) parseDays(days string) error {
+	if days == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+
+		return nil
+	}
+
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("unknown day %q", from)
+		}
+
+		last, ok := weekdays[to]
+		if !ok {
+			return fmt.Errorf("unknown day %q", to)
+		}
+
+		// ranges may wrap around the end of the week (e.g. Fri-Mon)
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active reports whether t falls in any of the schedule's windows. A schedule
+// with no windows is always active.
+func (s *schedule) active(t time.Time) bool {
+	if s == nil || len(s.windows) == 0 {
+		return true
+	}
+
+	// the wall-clock time of day, which isn't the time since midnight on
+	// days when DST starts or ends
+	t = t.In(s.loc)
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[today] && tod >= w.start && tod < w.end {
+				return true
+			}
+
+			continue
+		}
+
+		// overnight (and 24-hour) windows belong to the day they start on
+		if (w.days[today] && tod >= w.start) || (w.days[yesterday] && tod < w.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// awayTimeouts is the away timeout, which can differ by time of day - like a
+// short timeout during work hours, and a relaxed one in the evening.
+type awayTimeouts struct {
+	fallback time.Duration
+	profiles []awayProfile
+}
+
+// awayProfile is an away timeout which applies during a schedule's windows.
+type awayProfile struct {
+	when    *schedule
+	timeout time.Duration
+}
+
+// parseAwayTimeouts parses profiles of the form "[DAYS] HH:MM-HH:MM=TIMEOUT",
+// like "Mon-Fri 09:00-17:00=2m". The first profile whose window is active
+// applies, and otherwise the fallback timeout.
+func parseAwayTimeouts(specs []string, tz string, fallback time.Duration) (*awayTimeouts, error) {
+	a := &awayTimeouts{fallback: fallback}
+
+	for _, spec := range specs {
+		window, timeout, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid away timeout profile %q: expected \"[DAYS] HH:MM-HH:MM=TIMEOUT\"", spec)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(timeout))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid away timeout profile %q: invalid timeout %q", spec, timeout)
+		}
+
+		when, err := parseSchedule([]string{window}, tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid away timeout profile %q: %w", spec, err)
+		}
+
+		a.profiles = append(a.profiles, awayProfile{when: when, timeout: d})
+	}
+
+	return a, nil
+}
+
+// at returns the away timeout at t.
+func (a *awayTimeouts) at(t time.Time) time.Duration {
+	for _, p := range a.profiles {
+		if p.when.active(t) {
+			return p.timeout
+		}
+	}
+
+	return a.fallback
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+
+	at := func(s string) time.Time {
+		t.Helper()
+
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tm
+	}
+
+	tests := []struct {
+		name  string
+		specs []string
+		at    string
+		want  bool
+	}{
+		{"no windows", nil, "2024-05-03 03:00", true},
+		{"inside", []string{"Mon-Fri 08:00-18:00"}, "2024-05-03 12:00", true},
+		{"at start", []string{"Mon-Fri 08:00-18:00"}, "2024-05-03 08:00", true},
+		{"at end", []string{"Mon-Fri 08:00-18:00"}, "2024-05-03 18:00", false},
+		{"other day", []string{"Mon-Fri 08:00-18:00"}, "2024-05-04 12:00", false},
+		{"every day", []string{"08:00-18:00"}, "2024-05-04 12:00", true},
+		{"second window", []string{"Mon 08:00-09:00", "Fri 11:00-13:00"}, "2024-05-03 12:00", true},
+		{"wrapping days", []string{"Fri-Mon 08:00-18:00"}, "2024-05-05 12:00", true},
+		{"overnight, evening", []string{"Fri 22:00-06:00"}, "2024-05-03 23:00", true},
+		{"overnight, next morning", []string{"Fri 22:00-06:00"}, "2024-05-04 05:59", true},
+		{"overnight, after", []string{"Fri 22:00-06:00"}, "2024-05-04 06:00", false},
+		{"overnight, day before", []string{"Fri 22:00-06:00"}, "2024-05-03 05:00", false},
+		{"24 hours from midnight", []string{"Sat 00:00-00:00"}, "2024-05-04 12:00", true},
+		{"24 hours, day before", []string{"Sat 00:00-00:00"}, "2024-05-03 23:59", false},
+		{"24 hours, day after", []string{"Sat 00:00-00:00"}, "2024-05-05 00:00", false},
+		{"24 hours every day", []string{"22:00-22:00"}, "2024-05-05 21:59", true},
+		// on DST changes, times of day are by the wall clock
+		{"DST starts, inside", []string{"09:00-17:00"}, "2024-03-10 09:30", true},
+		{"DST starts, before", []string{"09:00-17:00"}, "2024-03-10 08:30", false},
+		{"DST ends, inside", []string{"09:00-17:00"}, "2024-11-03 16:30", true},
+		{"DST ends, after", []string{"09:00-17:00"}, "2024-11-03 17:30", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.specs, "America/New_York")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.active(at(tt.at)); got != tt.want {
+				t.Errorf("%q active at %s = %t, want %t", tt.specs, tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"08:00",
+		"Mon-Fri 08:00",
+		"Someday 08:00-18:00",
+		"Mon-Fri 8am-6pm",
+		"Mon Fri 08:00-18:00",
+	} {
+		if _, err := parseSchedule([]string{spec}, ""); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// boxSmoothing is how much of a detection's previous box is kept when it's
+// found again in the next frame, as an exponential moving average - higher
+// is smoother, but slower to follow movement (0 to disable). Guarded by
+// webcamMu, as it can be tuned at runtime.
+var boxSmoothing = 0.5
+
+// boxSmoother smooths a classifier's detections across frames, so that
+// annotations don't jitter, and detections near the size bounds don't flap in
+// and out of them.
+type boxSmoother struct {
+	prev []smoothedBox
+}
+
+// smoothedBox is a detection's smoothed corners, kept unrounded so small
+// movements accumulate
+type smoothedBox [4]float64
+
+func newSmoothedBox(r image.Rectangle) smoothedBox {
+	return smoothedBox{float64(r.Min.X), float64(r.Min.Y), float64(r.Max.X), float64(r.Max.Y)}
+}
+
+func (b smoothedBox) rect() image.Rectangle {
+	return image.Rect(
+		int(math.Round(b[0])), int(math.Round(b[1])),
+		int(math.Round(b[2])), int(math.Round(b[3])),
+	)
+}
+
+// smooth blends each detection with the overlapping detection from the
+// previous frame, if any, keeping alpha of the previous box. Detections which
+// aren't found again are forgotten.
+func (s *boxSmoother) smooth(found []image.Rectangle, alpha float64) []image.Rectangle {
+	if alpha <= 0 {
+		s.prev = nil
+		return found
+	}
+
+	next := make([]smoothedBox, len(found))
+	out := make([]image.Rectangle, len(found))
+	matched := make([]bool, len(s.prev))
+
+	for i, r := range found {
+		b := newSmoothedBox(r)
+
+		if j := s.match(r, matched); j >= 0 {
+			matched[j] = true
+			for k := range b {
+				b[k] = alpha*s.prev[j][k] + (1-alpha)*b[k]
+			}
+		}
+
+		next[i] = b
+		out[i] = b.rect()
+	}
+
+	s.prev = next
+
+	return out
+}
+
+// match returns the index of the unmatched previous box overlapping r the
+// most, or -1.
+func (s *boxSmoother) match(r image.Rectangle, matched []bool) int {
+	best, bestArea := -1, 0
+	for j, p := range s.prev {
+		if matched[j] {
+			continue
+		}
+
+		pr := p.rect()
+		if !overlaps(r, pr) {
+			continue
+		}
+
+		in := r.Intersect(pr)
+		if area := in.Dx() * in.Dy(); area > bestArea {
+			best, bestArea = j, area
+		}
+	}
+
+	return best
+}
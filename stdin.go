@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// stdinDevice is the -device which reads frames piped on stdin
+	stdinDevice = "-"
+	// stdinStale is how old the latest piped frame can be before the pipe is
+	// considered stalled
+	stdinStale = 5 * time.Second
+	// stdinMaxFrame limits the size of piped MJPEG frames
+	stdinMaxFrame = 16 << 20
+)
+
+// stdinConfig holds the settings for reading frames piped on stdin, from
+// cameras OpenCV can't capture from directly, like:
+//
+//	ffmpeg -f v4l2 -i /dev/video2 -f mjpeg - | presence -device=-
+type stdinConfig struct {
+	// format is mjpeg (concatenated JPEGs), or bgr24 (raw frames)
+	format string
+	// size is the size of raw frames, like 1280x720
+	size string
+}
+
+func (c *stdinConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "stdin-format", "mjpeg", "format of frames piped on stdin with -device=-: mjpeg, or bgr24 for raw frames")
+	fs.StringVar(&c.size, "stdin-size", "", "size of raw frames piped on stdin, like 1280x720 (needed with -stdin-format=bgr24)")
+}
+
+// piped holds the latest frame piped on stdin
+var piped = &stdinReceiver{}
+
+// stdinReceiver reads frames piped on stdin, and acts as the capture device
+// when -device=-. It keeps reading in the background, keeping only the latest
+// frame, so the producer is never blocked by slow detection.
+type stdinReceiver struct {
+	mu    sync.Mutex
+	frame gocv.Mat
+	at    time.Time
+	err   error
+}
+
+// Read copies the latest piped frame into m, failing when the pipe has
+// closed or stalled.
+func (r *stdinReceiver) Read(m *gocv.Mat) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil || r.frame.Empty() || time.Since(r.at) > stdinStale {
+		return false
+	}
+
+	r.frame.CopyTo(m)
+
+	return !m.Empty()
+}
+
+// Close does nothing, as stdin can't be reopened.
+func (r *stdinReceiver) Close() error {
+	return nil
+}
+
+// start reads frames from in, in the format configured, until it closes.
+func (r *stdinReceiver) start(c stdinConfig, in io.Reader) error {
+	var next func(*bufio.Reader) (gocv.Mat, error)
+
+	switch c.format {
+	case "mjpeg":
+		next = readJPEGFrame
+	case "bgr24":
+		w, h, err := parseFrameSize(c.size)
+		if err != nil {
+			return fmt.Errorf("invalid -stdin-size %q: %w", c.size, err)
+		}
+
+		next = func(br *bufio.Reader) (gocv.Mat, error) {
+			return readRawFrame(br, w, h)
+		}
+	default:
+		return fmt.Errorf("invalid -stdin-format %q: must be mjpeg or bgr24", c.format)
+	}
+
+	r.frame = gocv.NewMat()
+
+	go func() {
+		br := bufio.NewReaderSize(in, 1<<20)
+		for {
+			img, err := next(br)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					slog.Warn("stdin closed, no more frames")
+				} else {
+					slog.Error("reading frames from stdin", "err", err)
+				}
+
+				r.mu.Lock()
+				r.err = err
+				r.mu.Unlock()
+
+				return
+			}
+
+			if img.Empty() {
+				// a corrupt frame - wait for the next one
+				img.Close()
+				continue
+			}
+
+			r.mu.Lock()
+			img.CopyTo(&r.frame)
+			r.at = time.Now()
+			r.mu.Unlock()
+
+			img.Close()
+		}
+	}()
+
+	return nil
+}
+
+// parseFrameSize parses a frame size like 1280x720.
+func parseFrameSize(s string) (w, h int, err error) {
+	ws, hs, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0, errors.New("must be like 1280x720")
+	}
+
+	w, err = strconv.Atoi(ws)
+	if err != nil || w <= 0 {
+		return 0, 0, errors.New("invalid width")
+	}
+
+	h, err = strconv.Atoi(hs)
+	if err != nil || h <= 0 {
+		return 0, 0, errors.New("invalid height")
+	}
+
+	return w, h, nil
+}
+
+// readJPEGFrame reads the next JPEG from a stream of concatenated JPEGs, as
+// written by ffmpeg -f mjpeg or libcamera-vid --codec mjpeg, skipping any
+// bytes before its start marker.
+func readJPEGFrame(br *bufio.Reader) (gocv.Mat, error) {
+	soi, eoi := []byte{0xff, 0xd8}, []byte{0xff, 0xd9}
+
+	// find the start of image marker
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return gocv.Mat{}, err
+		}
+
+		if b != soi[0] {
+			continue
+		}
+
+		if next, err := br.Peek(1); err == nil && next[0] == soi[1] {
+			_, _ = br.ReadByte()
+			break
+		}
+	}
+
+	buf := bytes.NewBuffer(append(make([]byte, 0, 256*1024), soi...))
+
+	// markers in entropy-coded data are byte-stuffed, so the first end of
+	// image marker ends the frame
+	for !bytes.HasSuffix(buf.Bytes(), eoi) {
+		b, err := br.ReadByte()
+		if err != nil {
+			return gocv.Mat{}, err
+		}
+
+		buf.WriteByte(b)
+
+		if buf.Len() > stdinMaxFrame {
+			return gocv.Mat{}, fmt.Errorf("frame larger than %d bytes - is stdin MJPEG?", stdinMaxFrame)
+		}
+	}
+
+	img, err := gocv.IMDecode(buf.Bytes(), gocv.IMReadColor)
+	if err != nil {
+		return gocv.Mat{}, fmt.Errorf("decoding frame: %w", err)
+	}
+
+	return img, nil
+}
+
+// readRawFrame reads the next raw bgr24 frame of the given size.
+func readRawFrame(br *bufio.Reader, w, h int) (gocv.Mat, error) {
+	buf := make([]byte, w*h*3)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return gocv.Mat{}, err
+	}
+
+	return gocv.NewMatFromBytes(h, w, gocv.MatTypeCV8UC3, buf)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// streamWriteTimeout is how long writing a frame to a streaming client can
+// take before it's disconnected
+const streamWriteTimeout = 10 * time.Second
+
+// frameHub captures annotated frames continuously while anyone is watching,
+// through the frame pipeline, and fans the JPEGs out to the watchers. When
+// nobody is subscribed, no frames are captured beyond the background
+// detection loop.
+type frameHub struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// subs are the subscribers, by their channel
+	subs map[chan []byte]*frameSub
+	// stop stops the capture goroutine, nil when it isn't running
+	stop context.CancelFunc
+	// limited are placeholders sent in place of frames once the frame quota
+	// is used up, by width
+	limited map[int][]byte
+}
+
+func newFrameHub(fps float64) *frameHub {
+	return &frameHub{
+		interval: time.Duration(float64(time.Second) / fps),
+		subs:     map[chan []byte]*frameSub{},
+	}
+}
+
+// frameSub is a subscriber to the frame hub. Its counts are guarded by the
+// hub's mutex.
+type frameSub struct {
+	// stream is the kind of stream, like mjpeg or webrtc, and client
+	// identifies the viewer, for metrics
+	stream string
+	client string
+	width  int
+
+	sent    uint64
+	dropped uint64
+}
+
+// frameSubStats are a subscriber's queue metrics.
+type frameSubStats struct {
+	Stream string
+	Client string
+	Width  int
+	// Queued is the number of frames waiting for the subscriber
+	Queued  int
+	Sent    uint64
+	Dropped uint64
+}
+
+// subscribe returns a channel which receives frames scaled to width (0 for
+// full size), and a function to unsubscribe. Frames are dropped for
+// subscribers which haven't consumed the previous one, so slow watchers don't
+// hold up the others, or buffer frames without bound.
+func (h *frameHub) subscribe(stream, client string, width int) (<-chan []byte, func()) {
+	ch := make(chan []byte, 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subs[ch] = &frameSub{stream: stream, client: client, width: width}
+	if h.stop == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.stop = cancel
+
+		go h.runPipeline(ctx)
+	}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subs[ch]; !ok {
+			return
+		}
+
+		delete(h.subs, ch)
+		close(ch)
+
+		if len(h.subs) == 0 && h.stop != nil {
+			h.stop()
+			h.stop = nil
+		}
+	}
+}
+
+// captureFrames is the stream pipeline's capture stage. When capture isn't
+// happening, placeholders are published instead.
+func (h *frameHub) captureFrames(ctx context.Context, out *pipelineQueue) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		img := gocv.NewMat()
+
+		at, err := readFrame(&img)
+		if captureSkipped(err) {
+			img.Close()
+
+			imgs, err := placeholderFrames(placeholderMessage(err), h.widths())
+			if err != nil {
+				slog.Warn("rendering placeholder for streaming", "err", err)
+				continue
+			}
+
+			h.publish(imgs, false)
+			continue
+		}
+
+		if err != nil {
+			img.Close()
+			slog.Warn("capturing frame for streaming", "err", err)
+			continue
+		}
+
+		out.push(&pipelineFrame{img: img, at: at})
+	}
+}
+
+// detectFrames is the stream pipeline's detect stage.
+func (h *frameHub) detectFrames(ctx context.Context, in, out *pipelineQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-in.ch:
+			f.gen, _ = detectFrame(&f.img, nil, f.at)
+			out.push(f)
+		}
+	}
+}
+
+// encodeFrames is the stream pipeline's encode stage. Each frame is only
+// encoded once for each width, however many subscribers want it.
+func (h *frameHub) encodeFrames(ctx context.Context, in *pipelineQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-in.ch:
+			start := time.Now()
+			imgs, err := scene.encode(f.gen, f.img, h.widths(), false)
+			f.img.Close()
+
+			if err != nil {
+				slog.Warn("encoding frame for streaming", "err", err)
+				continue
+			}
+			encodeStage.timed(start)
+
+			h.publish(imgs, true)
+		}
+	}
+}
+
+// widths returns the distinct widths the subscribers want.
+func (h *frameHub) widths() []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	widths := []int{}
+	for _, sub := range h.subs {
+		if !slices.Contains(widths, sub.width) {
+			widths = append(widths, sub.width)
+		}
+	}
+
+	return widths
+}
+
+// publish sends the frames to the subscribers, at their widths. Camera
+// frames (rather than placeholders) count towards the frame quota, except for
+// the virtual camera, which isn't served through the API.
+func (h *frameHub) publish(imgs map[int][]byte, camera bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for ch, sub := range h.subs {
+		img, ok := imgs[sub.width]
+		if !ok {
+			// subscribed since the frame was captured
+			continue
+		}
+
+		// only this goroutine sends, so a frame is only counted when the
+		// subscriber has room for it
+		if len(ch) > 0 {
+			sub.dropped++
+			continue
+		}
+
+		if camera && sub.stream != "v4l2" {
+			if ok, _ := frameQuota.take(sub.stream, now); !ok {
+				if img = h.limitedFrame(sub.width); img == nil {
+					continue
+				}
+			}
+		}
+
+		select {
+		case ch <- img:
+			sub.sent++
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// limitedFrame returns the placeholder sent once the frame quota is used up,
+// or nil if it can't be rendered. Callers must hold h.mu.
+func (h *frameHub) limitedFrame(width int) []byte {
+	if img, ok := h.limited[width]; ok {
+		return img
+	}
+
+	imgs, err := placeholderFrames(placeholderMessage(errFrameQuota), []int{width})
+	if err != nil {
+		slog.Warn("rendering placeholder for streaming", "err", err)
+		return nil
+	}
+
+	if h.limited == nil {
+		h.limited = map[int][]byte{}
+	}
+	h.limited[width] = imgs[width]
+
+	return imgs[width]
+}
+
+// stats returns each subscriber's queue metrics, ordered by stream and
+// client.
+func (h *frameHub) stats() []frameSubStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]frameSubStats, 0, len(h.subs))
+	for ch, sub := range h.subs {
+		stats = append(stats, frameSubStats{
+			Stream:  sub.stream,
+			Client:  sub.client,
+			Width:   sub.width,
+			Queued:  len(ch),
+			Sent:    sub.sent,
+			Dropped: sub.dropped,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Stream != stats[j].Stream {
+			return stats[i].Stream < stats[j].Stream
+		}
+
+		return stats[i].Client < stats[j].Client
+	})
+
+	return stats
+}
+
+// handleMJPEGStream streams the annotated frames as MJPEG
+// (multipart/x-mixed-replace), which browsers show in an <img>, and many
+// tools can read. A client too slow to keep up misses frames, and one which
+// stops reading is disconnected.
+func handleMJPEGStream(w http.ResponseWriter, r *http.Request) {
+	width, err := requestWidth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	frames, unsubscribe := streamFrames.subscribe("mjpeg", clientAddr(r), width)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	mw := multipart.NewWriter(w)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	slog.Info("MJPEG viewer connected", "client", clientAddr(r))
+	defer slog.Info("MJPEG viewer disconnected", "client", clientAddr(r))
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case img, ok := <-frames:
+			if !ok {
+				return
+			}
+
+			_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+
+			if err := writeMJPEGFrame(mw, img); err != nil {
+				slog.Debug("writing MJPEG frame", "err", err)
+				return
+			}
+
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeMJPEGFrame(mw *multipart.Writer, img []byte) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":   {"image/jpeg"},
+		"Content-Length": {strconv.Itoa(len(img))},
+	})
+	if err != nil {
+		return fmt.Errorf("writing part header: %w", err)
+	}
+
+	_, err = part.Write(img)
+
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sdWatchdog pings the systemd watchdog from the detection loop, so a wedged
+// loop gets the service restarted. It's nil when the watchdog isn't enabled.
+var sdWatchdog = newWatchdog()
+
+// sdNotify sends a state notification (like "READY=1") to systemd. It does
+// nothing when not running as a Type=notify service.
+func sdNotify(state string) error {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return nil
+	}
+
+	// a leading @ is an abstract socket, which net handles
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connecting to systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("notifying systemd: %w", err)
+	}
+
+	return nil
+}
+
+// watchdog pings the systemd watchdog, at most every interval.
+type watchdog struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newWatchdog returns a watchdog when systemd has enabled it for this
+// process (WatchdogSec= in the unit), or nil otherwise.
+func newWatchdog() *watchdog {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return nil
+	}
+
+	// ping at twice the required rate, as systemd recommends
+	return &watchdog{interval: time.Duration(usec) * time.Microsecond / 2}
+}
+
+// ping tells systemd the process is still alive.
+func (w *watchdog) ping() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(w.last) < w.interval {
+		return
+	}
+	w.last = now
+
+	if err := sdNotify("WATCHDOG=1"); err != nil {
+		slog.Warn("pinging systemd watchdog", "err", err)
+	}
+}
+
+// run pings the watchdog regularly, for when there's no detection loop to
+// ping it.
+func (w *watchdog) run() {
+	if w == nil {
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.ping()
+	}
+}
+
+// unitTemplate is a systemd service for the daemon.
+const unitTemplate = `[Unit]
+Description=presence - human presence detection
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart=%s
+Restart=on-failure
+RestartSec=5s
+WatchdogSec=%d
+%s
+[Install]
+WantedBy=%s
+`
+
+// runSystemdUnit implements the systemd-unit subcommand, which prints a
+// systemd service unit running the daemon with the given arguments, like:
+//
+//	presence systemd-unit -user=presence -- -device=1 -listen=:8888
+func runSystemdUnit(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	user := fs.String("user", "", "user to run the service as (system units only, default root)")
+	userUnit := fs.Bool("user-unit", false, "generate a user unit (for systemctl --user)")
+	watchdogSec := fs.Duration("watchdog", 30*time.Second, "restart the service when the detection loop is stuck for this long")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding executable: %w", err)
+	}
+
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return fmt.Errorf("finding executable: %w", err)
+	}
+
+	execStart := []string{systemdQuote(exe)}
+	for _, a := range fs.Args() {
+		execStart = append(execStart, systemdQuote(a))
+	}
+
+	extra := ""
+	if *user != "" && !*userUnit {
+		extra += "User=" + *user + "\n"
+	}
+	if !*userUnit {
+		// for access to the camera
+		extra += "SupplementaryGroups=video\n"
+	}
+
+	wantedBy := "multi-user.target"
+	if *userUnit {
+		wantedBy = "default.target"
+	}
+
+	fmt.Printf(unitTemplate, strings.Join(execStart, " "), int(watchdogSec.Seconds()), extra, wantedBy)
+
+	return nil
+}
+
+// systemdQuote quotes a command line argument for ExecStart=, escaping the
+// specifiers and variables systemd would otherwise expand.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateConfig holds the settings for the templated HTTP output, which
+// renders a user-supplied Go template with the event and sends the result to
+// an arbitrary URL - enough to speak most webhook dialects (Discord, Gotify,
+// IFTTT, ...).
+type templateConfig struct {
+	url         string
+	method      string
+	file        string
+	contentType string
+	events      string
+	headers     stringsFlag
+	throttle    throttleConfig
+}
+
+func (c *templateConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.url, "template-url", "", "URL to send templated event payloads to - enables the templated output")
+	fs.StringVar(&c.method, "template-method", http.MethodPost, "HTTP method for templated event payloads")
+	fs.StringVar(&c.file, "template-file", "", "Go template file rendering the event payload (default: the event as JSON)")
+	fs.StringVar(&c.contentType, "template-content-type", "application/json", "Content-Type of the rendered payload")
+	fs.StringVar(&c.events, "template-events", "arrival,departure", "comma-separated events to send templated payloads for")
+	fs.Var(&c.headers, "template-header", "extra header (\"Name: value\") to send with templated payloads; may be repeated")
+	c.throttle.registerFlags(fs, "template", "templated payloads")
+}
+
+func (c templateConfig) enabled() bool {
+	return c.url != ""
+}
+
+// defaultTemplate renders the whole event as JSON.
+const defaultTemplate = `{{ json . }}`
+
+var templateFuncs = template.FuncMap{
+	// json renders a value as JSON, which is also handy for quoting strings
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"base64": func(b []byte) string {
+		return base64.StdEncoding.EncodeToString(b)
+	},
+	"formatTime": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"unix": func(t time.Time) int64 {
+		return t.Unix()
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+type templateNotifier struct {
+	cfg    templateConfig
+	tmpl   *template.Template
+	header http.Header
+	events map[eventType]bool
+	hc     *http.Client
+}
+
+func newTemplateNotifier(cfg templateConfig) (*templateNotifier, error) {
+	src := defaultTemplate
+	if cfg.file != "" {
+		b, err := os.ReadFile(cfg.file)
+		if err != nil {
+			return nil, fmt.Errorf("reading template: %w", err)
+		}
+		src = string(b)
+	}
+
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	n := &templateNotifier{
+		cfg:    cfg,
+		tmpl:   tmpl,
+		header: http.Header{},
+		hc:     &http.Client{Timeout: 30 * time.Second},
+	}
+
+	n.header.Set("Content-Type", cfg.contentType)
+	for _, h := range cfg.headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, must be \"Name: value\"", h)
+		}
+		n.header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+
+	n.events, err = parseEventTypes(cfg.events)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (n *templateNotifier) notify(ctx context.Context, ev event) error {
+	if !n.events[ev.Type] {
+		return nil
+	}
+
+	body := &bytes.Buffer{}
+	if err := n.tmpl.Execute(body, ev); err != nil {
+		return fmt.Errorf("rendering template: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, n.cfg.method, n.cfg.url, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header = n.header.Clone()
+
+	resp, err := n.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending templated payload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("templated output %s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	slog.Info("Sent templated payload", "event", ev.Type, "host", req.URL.Host)
+
+	return nil
+}
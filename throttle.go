@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// throttleConfig holds an output's settings for suppressing brief detection
+// glitches and repeated notifications.
+type throttleConfig struct {
+	// interval is the minimum time between events of the same type
+	interval time.Duration
+	// settle is how long a new state must last before it's sent
+	settle time.Duration
+}
+
+// registerFlags registers -<prefix>-interval and -<prefix>-settle.
+func (c *throttleConfig) registerFlags(fs *flag.FlagSet, prefix, output string) {
+	fs.DurationVar(&c.interval, prefix+"-interval", 0, "minimum time between "+output+" for the same event type - more are dropped (0 for no minimum)")
+	c.registerSettleFlag(fs, prefix, output)
+}
+
+// registerSettleFlag registers only -<prefix>-settle, for outputs with their
+// own interval.
+func (c *throttleConfig) registerSettleFlag(fs *flag.FlagSet, prefix, output string) {
+	fs.DurationVar(&c.settle, prefix+"-settle", 0, "how long a new state must last before "+output+" are sent - flaps back to the previous state within this time are dropped (0 to send immediately)")
+}
+
+func (c throttleConfig) enabled() bool {
+	return c.interval > 0 || c.settle > 0
+}
+
+// throttle wraps the notifier so it's throttled according to the config, if
+// enabled.
+func (c throttleConfig) throttle(name string, n notifier) notifier {
+	if !c.enabled() {
+		return n
+	}
+
+	return &throttledNotifier{
+		name:    name,
+		cfg:     c,
+		next:    n,
+		pending: map[string]*pendingEvent{},
+		settled: map[string]string{},
+		last:    map[throttleKey]time.Time{},
+	}
+}
+
+// throttledNotifier passes events on to another notifier, once they've
+// settled, and no more often than the interval. Zones are throttled
+// independently of each other, and of the whole frame.
+type throttledNotifier struct {
+	name string
+	cfg  throttleConfig
+	next notifier
+
+	// flushing counts the settle timers which haven't finished - pending, or
+	// sending
+	flushing sync.WaitGroup
+
+	mu sync.Mutex
+	// stopped is set once stopped, after which events are dropped
+	stopped bool
+	// pending are the events waiting to settle, by zone
+	pending map[string]*pendingEvent
+	// settled are the states of the last settled events, by zone - or the
+	// state before the first event
+	settled map[string]string
+	// last are when each type of event was last sent
+	last map[throttleKey]time.Time
+}
+
+type pendingEvent struct {
+	ev    event
+	timer *time.Timer
+}
+
+type throttleKey struct {
+	zone string
+	typ  eventType
+}
+
+func (t *throttledNotifier) notify(ctx context.Context, ev event) error {
+	if t.cfg.settle <= 0 {
+		return t.send(ctx, ev)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return nil
+	}
+
+	// events are changes of state, so before the zone's first one it was in
+	// the other state - a flap back to it is dropped, even at startup
+	if _, ok := t.settled[ev.Zone]; !ok {
+		t.settled[ev.Zone] = "present"
+		if ev.Type == eventArrival {
+			t.settled[ev.Zone] = "away"
+		}
+	}
+
+	// a newer event replaces a pending one, and waits to settle itself
+	if p := t.pending[ev.Zone]; p != nil && p.timer.Stop() {
+		t.flushing.Done()
+	}
+
+	p := &pendingEvent{ev: ev}
+	t.flushing.Add(1)
+	p.timer = time.AfterFunc(t.cfg.settle, func() { t.flush(p) })
+	t.pending[ev.Zone] = p
+
+	return nil
+}
+
+// stop drops the events which haven't settled yet, and waits for any being
+// sent, so nothing is sent once the outputs are closed.
+func (t *throttledNotifier) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	for zone, p := range t.pending {
+		if p.timer.Stop() {
+			slog.Info("Dropping unsettled event on shutdown", "output", t.name, "event", p.ev.Type, "zone", zone)
+			t.flushing.Done()
+		}
+		delete(t.pending, zone)
+	}
+	t.mu.Unlock()
+
+	t.flushing.Wait()
+}
+
+// flush sends a pending event once it's settled.
+func (t *throttledNotifier) flush(p *pendingEvent) {
+	defer t.flushing.Done()
+
+	t.mu.Lock()
+	if t.pending[p.ev.Zone] != p {
+		// replaced while the timer fired
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, p.ev.Zone)
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := t.send(ctx, p.ev); err != nil {
+		slog.Error("sending notification", "output", t.name, "event", p.ev.Type, "err", err)
+	}
+}
+
+// send passes the event on, unless it returns to the last settled state (a
+// collapsed flap), or it's too soon after the last of its type.
+func (t *throttledNotifier) send(ctx context.Context, ev event) error {
+	t.mu.Lock()
+
+	if t.settled[ev.Zone] == ev.State {
+		t.mu.Unlock()
+		slog.Debug("Dropping event, state flapped back", "output", t.name, "event", ev.Type, "zone", ev.Zone)
+
+		return nil
+	}
+	t.settled[ev.Zone] = ev.State
+
+	key := throttleKey{zone: ev.Zone, typ: ev.Type}
+	if last, ok := t.last[key]; ok && t.cfg.interval > 0 && ev.Time.Sub(last) < t.cfg.interval {
+		t.mu.Unlock()
+		slog.Info("Dropping event, too soon after the last one", "output", t.name, "event", ev.Type, "zone", ev.Zone, "last", last)
+
+		return nil
+	}
+	t.last[key] = ev.Time
+
+	t.mu.Unlock()
+
+	return t.next.notify(ctx, ev)
+}
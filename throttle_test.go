@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottledNotifierSettle(t *testing.T) {
+	t0 := time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)
+	arrival := func(zone string) event {
+		return event{Type: eventArrival, State: "present", Time: t0, Zone: zone}
+	}
+	departure := func(zone string) event {
+		return event{Type: eventDeparture, State: "away", Time: t0, Zone: zone}
+	}
+
+	tests := []struct {
+		name string
+		// batches are sent to the notifier in turn, each settling before the
+		// next
+		batches [][]event
+		want    []eventType
+	}{
+		{
+			name:    "settles",
+			batches: [][]event{{arrival("")}},
+			want:    []eventType{eventArrival},
+		},
+		{
+			name:    "flap as the first event",
+			batches: [][]event{{arrival(""), departure("")}},
+		},
+		{
+			name:    "flap after a settled event",
+			batches: [][]event{{arrival("")}, {departure(""), arrival("")}},
+			want:    []eventType{eventArrival},
+		},
+		{
+			name:    "latest event settles",
+			batches: [][]event{{departure(""), arrival(""), departure("")}},
+			want:    []eventType{eventDeparture},
+		},
+		{
+			name:    "zones are independent",
+			batches: [][]event{{arrival("desk"), arrival(""), departure("desk")}},
+			want:    []eventType{eventArrival},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &recordingNotifier{}
+			tn := throttleConfig{settle: 10 * time.Millisecond}.throttle("test", n).(*throttledNotifier)
+
+			for _, batch := range tt.batches {
+				for _, ev := range batch {
+					if err := tn.notify(context.Background(), ev); err != nil {
+						t.Fatal(err)
+					}
+				}
+				tn.flushing.Wait()
+			}
+
+			got := n.take()
+			if len(got) != len(tt.want) {
+				t.Fatalf("sent %d events, want %d", len(got), len(tt.want))
+			}
+
+			for i := range got {
+				if got[i].Type != tt.want[i] {
+					t.Errorf("event %d is %s, want %s", i, got[i].Type, tt.want[i])
+				}
+			}
+		})
+	}
+}
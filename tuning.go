@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// tuningMu serializes changes to the detection parameters
+	tuningMu sync.Mutex
+	// watchInterval is the background detection interval, guarded by tuningMu
+	watchInterval time.Duration
+	// watchIntervals passes changes of the interval to the background
+	// detection loop
+	watchIntervals = make(chan time.Duration, 1)
+)
+
+// equalize equalizes the histogram of frames before detection, spreading out
+// their brightness, which helps find faces in dim or flat light. Guarded by
+// webcamMu, as it can be tuned at runtime.
+var equalize bool
+
+// detectionTuning are the detection parameters which can be tuned at runtime
+// with PUT /config/detection, without restarting.
+type detectionTuning struct {
+	// MinFace, MaxFace, MinFacePx, and MaxFacePx bound the size of faces, like
+	// -min-face, -max-face, -min-face-px, and -max-face-px
+	MinFace   float64 `json:"minFace"`
+	MaxFace   float64 `json:"maxFace"`
+	MinFacePx int     `json:"minFacePx"`
+	MaxFacePx int     `json:"maxFacePx"`
+	// Classifiers are the built-in classifiers to enable, like -classifiers
+	Classifiers  string  `json:"classifiers"`
+	BoxSmoothing float64 `json:"boxSmoothing"`
+	// Equalize equalizes frames' histograms before detection, like -equalize
+	Equalize bool `json:"equalize"`
+	// AwayTimeout is the away timeout outside of any -away-timeout-at
+	// windows, and Interval the background detection interval, like "30s"
+	AwayTimeout string `json:"awayTimeout"`
+	Interval    string `json:"interval"`
+}
+
+// currentTuning returns the detection parameters in effect.
+func currentTuning() detectionTuning {
+	webcamMu.Lock()
+	size, classifiers, smoothing, eq := detectorCfg.faceSize, detectorCfg.Classifiers, boxSmoothing, equalize
+	webcamMu.Unlock()
+
+	tuningMu.Lock()
+	interval := watchInterval
+	tuningMu.Unlock()
+
+	return detectionTuning{
+		MinFace:      size.min,
+		MaxFace:      size.max,
+		MinFacePx:    size.minPx,
+		MaxFacePx:    size.maxPx,
+		Classifiers:  classifiers,
+		BoxSmoothing: smoothing,
+		Equalize:     eq,
+		AwayTimeout:  tracker.awayTimeoutFallback().String(),
+		Interval:     interval.String(),
+	}
+}
+
+// applyTuning validates the detection parameters and puts them into effect.
+// The classifiers are only reloaded when the face size bounds or the enabled
+// classifiers change.
+func applyTuning(t detectionTuning) error {
+	size := faceSizeConfig{min: t.MinFace, max: t.MaxFace, minPx: t.MinFacePx, maxPx: t.MaxFacePx}
+	if err := size.validate(); err != nil {
+		return err
+	}
+
+	if t.BoxSmoothing < 0 || t.BoxSmoothing >= 1 {
+		return fmt.Errorf("invalid boxSmoothing %v: must be at least 0 and less than 1", t.BoxSmoothing)
+	}
+
+	away, err := time.ParseDuration(t.AwayTimeout)
+	if err != nil || away <= 0 {
+		return fmt.Errorf("invalid awayTimeout %q: must be a positive duration", t.AwayTimeout)
+	}
+
+	interval, err := time.ParseDuration(t.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval %q: %w", t.Interval, err)
+	}
+
+	tuningMu.Lock()
+	defer tuningMu.Unlock()
+
+	switch {
+	case detectInterval <= 0 && interval != 0:
+		return errors.New("background detection is disabled with -interval=0, so its interval can't be changed")
+	case detectInterval > 0 && interval <= 0:
+		return fmt.Errorf("invalid interval %v: must be positive", interval)
+	}
+
+	webcamMu.Lock()
+	cfg := detectorCfg
+	webcamMu.Unlock()
+
+	if cfg.faceSize != size || cfg.Classifiers != t.Classifiers {
+		cfg.faceSize, cfg.Classifiers = size, t.Classifiers
+		if err := setDetector(cfg); err != nil {
+			return err
+		}
+	}
+
+	webcamMu.Lock()
+	boxSmoothing, equalize = t.BoxSmoothing, t.Equalize
+	webcamMu.Unlock()
+
+	tracker.setAwayTimeout(away)
+
+	if interval != watchInterval {
+		watchInterval = interval
+
+		// replace any change the loop hasn't picked up yet
+		select {
+		case <-watchIntervals:
+		default:
+		}
+		watchIntervals <- interval
+	}
+
+	slog.Info("Detection tuned", "minFace", t.MinFace, "maxFace", t.MaxFace, "minFacePx", t.MinFacePx, "maxFacePx", t.MaxFacePx,
+		"classifiers", t.Classifiers, "boxSmoothing", t.BoxSmoothing, "equalize", t.Equalize, "awayTimeout", away, "interval", interval)
+
+	return nil
+}
+
+// loadTuning applies the detection parameters persisted to path, if it
+// exists. Parameters missing from the file keep their current values.
+func loadTuning(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t := currentTuning()
+	if err := json.Unmarshal(b, &t); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if err := applyTuning(t); err != nil {
+		return fmt.Errorf("applying %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// saveTuning persists the detection parameters to path, replacing it
+// atomically.
+func saveTuning(path string, t detectionTuning) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// writeFileAtomic writes the file through a temporary file, renamed into
+// place, so readers never see it partly written.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func handleGetDetection(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(currentTuning())
+}
+
+// handlePutDetection tunes the detection parameters. Parameters missing from
+// the request keep their current values.
+func handlePutDetection(w http.ResponseWriter, r *http.Request) {
+	t := currentTuning()
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		http.Error(w, fmt.Sprintf("invalid detection parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := applyTuning(t); err != nil {
+		slog.Warn("tuning detection", "err", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	base := profiles.tuned(currentTuning())
+
+	if tuningFile != "" {
+		if err := saveTuning(tuningFile, base); err != nil {
+			slog.Error("persisting detection parameters", "file", tuningFile, "err", err)
+			http.Error(w, fmt.Sprintf("tuned, but not persisted: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	handleGetDetection(w, r)
+}
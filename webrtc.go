@@ -0,0 +1,187 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// webrtcChunkSize keeps data channel messages well under the maximum
+	// message size supported by browsers
+	webrtcChunkSize = 16 * 1024
+	// webrtcMaxBuffered is how much unsent data a viewer's data channel can
+	// have before frames are dropped for it
+	webrtcMaxBuffered = 1024 * 1024
+	// webrtcGatherTimeout bounds how long to wait for ICE candidates
+	webrtcGatherTimeout = 10 * time.Second
+)
+
+var (
+	//go:embed webrtc.html
+	webrtcPage     string
+	webrtcPageTmpl = template.Must(template.New("webrtc").Parse(webrtcPage))
+
+	// iceServers are STUN/TURN server URLs for WebRTC, needed for viewers
+	// outside of the local network
+	iceServers stringsFlag
+)
+
+func webrtcConfig() webrtc.Configuration {
+	cfg := webrtc.Configuration{}
+	if len(iceServers) > 0 {
+		cfg.ICEServers = []webrtc.ICEServer{{URLs: iceServers}}
+	}
+
+	return cfg
+}
+
+// handleWebRTCViewer serves a page which plays the WebRTC stream.
+func handleWebRTCViewer(w http.ResponseWriter, _ *http.Request) {
+	servers := []map[string]any{}
+	if len(iceServers) > 0 {
+		servers = append(servers, map[string]any{"urls": []string(iceServers)})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := webrtcPageTmpl.Execute(w, map[string]any{"ICEServers": servers}); err != nil {
+		slog.Error("rendering WebRTC viewer", "err", err)
+	}
+}
+
+// handleWebRTCOffer accepts an SDP offer from a viewer, and answers it. Once
+// connected, annotated frames are sent as JPEGs over the data channel the
+// viewer opens - this avoids needing a video encoder, while keeping latency
+// well under a second.
+func handleWebRTCOffer(w http.ResponseWriter, r *http.Request) {
+	width, err := requestWidth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offer := webrtc.SessionDescription{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&offer); err != nil {
+		http.Error(w, "invalid offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtcConfig())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		slog.Debug("WebRTC connection state changed", "state", s)
+
+		switch s {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected:
+			_ = pc.Close()
+		}
+	})
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			go sendFrames(dc, clientAddr(r), width)
+		})
+	})
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		_ = pc.Close()
+		http.Error(w, "invalid offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		_ = pc.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		_ = pc.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case <-gathered:
+	case <-time.After(webrtcGatherTimeout):
+		slog.Warn("Timed out gathering ICE candidates, answering with what we have")
+	case <-r.Context().Done():
+		_ = pc.Close()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pc.LocalDescription()); err != nil {
+		slog.Error("writing WebRTC answer", "err", err)
+	}
+}
+
+// sendFrames streams frames at the given width over the data channel until it
+// closes.
+func sendFrames(dc *webrtc.DataChannel, client string, width int) {
+	closed := make(chan struct{})
+	once := sync.Once{}
+	dc.OnClose(func() {
+		once.Do(func() { close(closed) })
+	})
+
+	frames, unsubscribe := streamFrames.subscribe("webrtc", client, width)
+	defer unsubscribe()
+
+	slog.Info("WebRTC viewer connected")
+	defer slog.Info("WebRTC viewer disconnected")
+
+	for {
+		select {
+		case <-closed:
+			return
+		case img, ok := <-frames:
+			if !ok {
+				return
+			}
+
+			if dc.BufferedAmount() > webrtcMaxBuffered {
+				continue
+			}
+
+			if err := sendChunked(dc, img); err != nil {
+				slog.Debug("sending WebRTC frame", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// sendChunked sends a frame in chunks, each prefixed with a byte which is 1
+// for the frame's last chunk and 0 otherwise.
+func sendChunked(dc *webrtc.DataChannel, img []byte) error {
+	for len(img) > 0 {
+		n := min(len(img), webrtcChunkSize)
+
+		msg := make([]byte, n+1)
+		if n == len(img) {
+			msg[0] = 1
+		}
+		copy(msg[1:], img[:n])
+
+		if err := dc.Send(msg); err != nil {
+			return err
+		}
+
+		img = img[n:]
+	}
+
+	return nil
+}
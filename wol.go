@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// wolConfig holds the settings for waking machines with Wake-on-LAN when
+// presence is detected.
+type wolConfig struct {
+	macs stringsFlag
+	addr string
+}
+
+func (c *wolConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Var(&c.macs, "wol-mac", "MAC address to send a Wake-on-LAN magic packet to on arrival (repeatable)")
+	fs.StringVar(&c.addr, "wol-addr", "255.255.255.255:9", "broadcast address and port to send Wake-on-LAN packets to")
+}
+
+func (c wolConfig) enabled() bool {
+	return len(c.macs) > 0
+}
+
+// wolNotifier sends Wake-on-LAN magic packets on arrival.
+type wolNotifier struct {
+	addr    string
+	packets [][]byte
+}
+
+func newWOLNotifier(cfg wolConfig) (*wolNotifier, error) {
+	if _, err := net.ResolveUDPAddr("udp4", cfg.addr); err != nil {
+		return nil, fmt.Errorf("invalid -wol-addr %q: %w", cfg.addr, err)
+	}
+
+	n := &wolNotifier{addr: cfg.addr}
+
+	for _, m := range cfg.macs {
+		mac, err := net.ParseMAC(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -wol-mac: %w", err)
+		}
+
+		if len(mac) != 6 {
+			return nil, fmt.Errorf("invalid -wol-mac %q: must be a 48-bit MAC address", m)
+		}
+
+		n.packets = append(n.packets, magicPacket(mac))
+	}
+
+	return n, nil
+}
+
+// magicPacket returns a Wake-on-LAN magic packet for the given MAC - 6 bytes
+// of 0xff followed by the MAC 16 times.
+func magicPacket(mac net.HardwareAddr) []byte {
+	return append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)
+}
+
+func (n *wolNotifier) notify(ctx context.Context, ev event) error {
+	if ev.Type != eventArrival {
+		return nil
+	}
+
+	d := net.Dialer{}
+
+	conn, err := d.DialContext(ctx, "udp4", n.addr)
+	if err != nil {
+		return fmt.Errorf("wake-on-lan: %w", err)
+	}
+	defer conn.Close()
+
+	for _, p := range n.packets {
+		if _, err := conn.Write(p); err != nil {
+			return fmt.Errorf("wake-on-lan: %w", err)
+		}
+	}
+
+	slog.Info("Sent Wake-on-LAN packets", "count", len(n.packets), "addr", n.addr)
+
+	return nil
+}
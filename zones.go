@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zoneConfig holds the named regions of the frame which presence is tracked
+// in independently.
+type zoneConfig struct {
+	specs stringsFlag
+}
+
+func (c *zoneConfig) registerFlags(fs *flag.FlagSet) {
+	fs.Var(&c.specs, "zone", "named region of the frame to track presence in separately, as fractions of the frame like desk=0,0,0.5,1 (x,y,width,height); may be repeated")
+}
+
+func (c zoneConfig) enabled() bool {
+	return len(c.specs) > 0
+}
+
+// zone is a named region of the frame, in fractions of the frame's size.
+type zone struct {
+	name       string
+	x, y, w, h float64
+
+	known    bool
+	present  bool
+	since    time.Time
+	lastSeen time.Time
+	faces    int
+}
+
+// parseZones parses -zone specs, like "desk=0,0,0.5,1".
+func parseZones(specs []string) ([]*zone, error) {
+	zones := []*zone{}
+	names := map[string]bool{}
+
+	for _, spec := range specs {
+		name, rect, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid -zone %q: must be like desk=0,0,0.5,1", spec)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("duplicate -zone %q", name)
+		}
+		names[name] = true
+
+		parts := strings.Split(rect, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid -zone %q: must have x,y,width,height", spec)
+		}
+
+		v := make([]float64, 4)
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil || f < 0 || f > 1 {
+				return nil, fmt.Errorf("invalid -zone %q: %q must be a fraction of the frame, from 0 to 1", spec, p)
+			}
+			v[i] = f
+		}
+
+		z := &zone{name: name, x: v[0], y: v[1], w: v[2], h: v[3]}
+		if z.w == 0 || z.h == 0 || z.x+z.w > 1 || z.y+z.h > 1 {
+			return nil, fmt.Errorf("invalid -zone %q: must be a non-empty region within the frame", spec)
+		}
+
+		zones = append(zones, z)
+	}
+
+	return zones, nil
+}
+
+// contains reports whether the centre of the detection is within the zone,
+// in a frame of the given size.
+func (z *zone) contains(frame image.Point, d faceDetection) bool {
+	if frame.X <= 0 || frame.Y <= 0 {
+		return false
+	}
+
+	cx := (float64(d.X) + float64(d.Width)/2) / float64(frame.X)
+	cy := (float64(d.Y) + float64(d.Height)/2) / float64(frame.Y)
+
+	return cx >= z.x && cx < z.x+z.w && cy >= z.y && cy < z.y+z.h
+}
+
+// observe records the faces seen in the zone, returning an event when its
+// presence changes. Zones only use the camera, as other signals can't tell
+// where someone is.
+func (z *zone) observe(now time.Time, awayTimeout time.Duration, detections []faceDetection, camera string) *event {
+	z.faces = len(detections)
+
+	if z.faces > 0 {
+		z.lastSeen = now
+	}
+
+	present := z.faces > 0 || (z.known && now.Sub(z.lastSeen) < awayTimeout)
+	if !z.known {
+		z.known = true
+		z.present = present
+		z.since = now
+
+		return nil
+	}
+
+	if present == z.present {
+		return nil
+	}
+
+	z.present = present
+	z.since = now
+
+	ev := &event{
+		Type:       eventDeparture,
+		State:      "away",
+		Time:       now,
+		LastSeen:   z.lastSeen,
+		Faces:      z.faces,
+		Camera:     camera,
+		Zone:       z.name,
+		Detections: detections,
+	}
+	if present {
+		ev.Type = eventArrival
+		ev.State = "present"
+	}
+
+	return ev
+}
+
+// zoneStatus is a zone's presence state.
+type zoneStatus struct {
+	Name string `json:"name"`
+	// State is "present", "away", or "unknown"
+	State    string    `json:"state"`
+	Present  bool      `json:"present"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"lastSeen"`
+	Faces    int       `json:"faces"`
+}
+
+func (z *zone) status() zoneStatus {
+	return zoneStatus{
+		Name:     z.name,
+		State:    presenceStatus{Known: z.known, Present: z.present}.State(),
+		Present:  z.present,
+		Since:    z.since,
+		LastSeen: z.lastSeen,
+		Faces:    z.faces,
+	}
+}
+
+// handleZones responds with each zone's presence state.
+func handleZones(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(tracker.zoneStatuses()); err != nil {
+		slog.Error("writing zones", "err", err)
+	}
+}